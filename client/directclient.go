@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/troubling/hummingbird/hummingbird"
@@ -51,10 +52,12 @@ func mkquery(options map[string]string) string {
 }
 
 type ProxyDirectClient struct {
-	client        *http.Client
-	AccountRing   hummingbird.Ring
-	ContainerRing hummingbird.Ring
-	ObjectRing    hummingbird.Ring
+	client                 *http.Client
+	AccountRing            hummingbird.Ring
+	ContainerRing          hummingbird.Ring
+	ObjectRing             hummingbird.Ring
+	parallelGetThreshold   int64
+	parallelGetSegmentSize int64
 }
 
 func (c *ProxyDirectClient) quorumResponse(reqs ...*http.Request) int {
@@ -305,19 +308,48 @@ func (c *ProxyDirectClient) DeleteContainer(account string, container string, he
 	return c.quorumResponse(reqs...)
 }
 
+// readySignaler signals on ready the first time its body is read, which the transport doesn't do until the backend
+// has answered the request's Expect: 100-continue.  The transport closes it if the backend responds without taking
+// the body, which fails any pending writes to that backend's pipe.
+type readySignaler struct {
+	*io.PipeReader
+	ready chan struct{}
+	once  sync.Once
+}
+
+func (r *readySignaler) Read(p []byte) (int, error) {
+	r.once.Do(func() { r.ready <- struct{}{} })
+	return r.PipeReader.Read(p)
+}
+
+// writeLive writes p to each of writers, returning the ones that are still taking the body.
+func writeLive(writers []*io.PipeWriter, p []byte) []*io.PipeWriter {
+	live := writers[:0]
+	for _, writer := range writers {
+		if _, err := writer.Write(p); err == nil {
+			live = append(live, writer)
+		}
+	}
+	return live
+}
+
 func (c *ProxyDirectClient) PutObject(account string, container string, obj string, headers http.Header, src io.Reader) int {
 	partition := c.ObjectRing.GetPartition(account, container, obj)
 	containerPartition := c.ContainerRing.GetPartition(account, container, "")
 	containerDevices := c.ContainerRing.GetNodes(containerPartition)
 	var writers []*io.PipeWriter
 	reqs := make([]*http.Request, 0)
-	for i, device := range c.ObjectRing.GetNodes(partition) {
+	nodes := c.ObjectRing.GetNodes(partition)
+	ready := make(chan struct{}, len(nodes))
+	done := make(chan struct{})
+	defer close(done)
+	for i, device := range nodes {
 		url := fmt.Sprintf("http://%s:%d/%s/%d/%s/%s/%s", device.Ip, device.Port, device.Device, partition,
 			hummingbird.Urlencode(account), hummingbird.Urlencode(container), hummingbird.Urlencode(obj))
 		rp, wp := io.Pipe()
 		defer wp.Close()
 		defer rp.Close()
-		req, err := http.NewRequest("PUT", url, rp)
+		req, err := http.NewRequest("PUT", url, &readySignaler{PipeReader: rp, ready: ready})
 		if err != nil {
 			continue
 		}
@@ -334,10 +366,32 @@ func (c *ProxyDirectClient) PutObject(account string, container string, obj stri
 		reqs = append(reqs, req)
 	}
 	go func() {
-		mw := io.MultiWriter(writers[0], writers[1], writers[2])
-		io.Copy(mw, src)
+		// don't read from src (which would send the client its own 100 Continue) until a quorum of backends will take the body.
+		for i := 0; i < int(math.Ceil(float64(len(reqs))/2.0)); i++ {
+			select {
+			case <-ready:
+			case <-done:
+				return
+			}
+		}
+		// backends that have already responded stop taking the body, so they're dropped rather than blocking the rest.
+		live := append([]*io.PipeWriter{}, writers...)
+		buf := make([]byte, 64*1024)
+		var srcErr error
+		for len(live) > 0 {
+			n, err := src.Read(buf)
+			if n > 0 {
+				live = writeLive(live, buf[:n])
+			}
+			if err != nil {
+				if err != io.EOF {
+					srcErr = err
+				}
+				break
+			}
+		}
 		for _, writer := range writers {
-			writer.Close()
+			writer.CloseWithError(srcErr)
 		}
 	}()
 	return c.quorumResponse(reqs...)
@@ -384,6 +438,10 @@ func (c *ProxyDirectClient) GetObject(account string, container string, obj stri
 	if resp == nil {
 		return nil, nil, 404
 	}
+	if c.parallelGetThreshold > 0 && resp.StatusCode == http.StatusOK && headers.Get("Range") == "" &&
+		resp.ContentLength >= c.parallelGetThreshold && resp.ContentLength > c.parallelGetSegmentSize && len(reqs) > 1 {
+		return c.parallelGet(resp, reqs), resp.Header, resp.StatusCode
+	}
 	return resp.Body, resp.Header, resp.StatusCode
 }
 
@@ -452,7 +510,21 @@ func (c *ProxyDirectClient) DeleteObject(account string, container string, obj s
 	return c.quorumResponse(reqs...)
 }
 
-func NewProxyDirectClient() (ProxyClient, error) {
+// newBackendTransport builds the transport used for requests to backend servers, sized from the proxy-server config.
+func newBackendTransport(config hummingbird.Config) *http.Transport {
+	return &http.Transport{
+		Dial: (&net.Dialer{
+			Timeout:   time.Duration(config.GetFloat("app:proxy-server", "conn_timeout", 10.0) * float64(time.Second)),
+			KeepAlive: 5 * time.Second,
+		}).Dial,
+		MaxIdleConns:        int(config.GetInt("app:proxy-server", "max_idle_conns", 0)),
+		MaxIdleConnsPerHost: int(config.GetInt("app:proxy-server", "max_idle_conns_per_host", 100)),
+		// wait for backends to answer "Expect: 100-continue" before sending them a body.
+		ExpectContinueTimeout: time.Duration(config.GetFloat("app:proxy-server", "expect_continue_timeout", 5.0) * float64(time.Second)),
+	}
+}
+
+func NewProxyDirectClient(config hummingbird.Config) (ProxyClient, error) {
 	c := &ProxyDirectClient{}
 	hashPathPrefix, hashPathSuffix, err := hummingbird.GetHashPrefixAndSuffix()
 	if err != nil {
@@ -471,13 +543,14 @@ func NewProxyDirectClient() (ProxyClient, error) {
 		return nil, err
 	}
 	c.client = &http.Client{
-		Transport: &http.Transport{
-			Dial: (&net.Dialer{
-				Timeout:   10 * time.Second,
-				KeepAlive: 5 * time.Second,
-			}).Dial,
-		},
-		Timeout: 120 * time.Minute,
+		Transport: newBackendTransport(config),
+		Timeout:   120 * time.Minute,
+	}
+	// objects at least parallel_get_threshold bytes are fetched as parallel_get_segment_size ranges from several nodes at once.
+	c.parallelGetThreshold = config.GetInt("app:proxy-server", "parallel_get_threshold", 0)
+	c.parallelGetSegmentSize = config.GetInt("app:proxy-server", "parallel_get_segment_size", 8*1024*1024)
+	if c.parallelGetSegmentSize <= 0 {
+		return nil, fmt.Errorf("Invalid parallel_get_segment_size %d", c.parallelGetSegmentSize)
 	}
 	return c, nil
 }
@@ -632,7 +705,7 @@ func (c *directClient) DeleteObject(container string, obj string, headers map[st
 
 // NewDirectClient creates a new direct client with the given account name.
 func NewDirectClient(account string) (Client, error) {
-	rdc, err := NewProxyDirectClient()
+	rdc, err := NewProxyDirectClient(hummingbird.Config{})
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,169 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/troubling/hummingbird/hummingbird"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackendTransportDefaults(t *testing.T) {
+	transport := newBackendTransport(hummingbird.Config{})
+	require.Equal(t, 0, transport.MaxIdleConns)
+	require.Equal(t, 100, transport.MaxIdleConnsPerHost)
+}
+
+func TestBackendTransportConfig(t *testing.T) {
+	config, err := hummingbird.StringConfig("[app:proxy-server]\nmax_idle_conns=500\nmax_idle_conns_per_host=20\nconn_timeout=0.5\n")
+	require.Nil(t, err)
+	transport := newBackendTransport(config)
+	require.Equal(t, 500, transport.MaxIdleConns)
+	require.Equal(t, 20, transport.MaxIdleConnsPerHost)
+}
+
+func TestBackendTransportReusesConnections(t *testing.T) {
+	var lock sync.Mutex
+	newConns := 0
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			lock.Lock()
+			newConns++
+			lock.Unlock()
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	c := &http.Client{Transport: newBackendTransport(hummingbird.Config{})}
+	for i := 0; i < 5; i++ {
+		resp, err := c.Get(ts.URL)
+		require.Nil(t, err)
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, 1, newConns)
+}
+
+// readRecorder records whether anything tried to read the PUT body.
+type readRecorder struct {
+	io.Reader
+	read bool
+}
+
+func (r *readRecorder) Read(p []byte) (int, error) {
+	r.read = true
+	return r.Reader.Read(p)
+}
+
+func putBackends(t *testing.T, handler http.HandlerFunc) (*fixedNodesRing, func()) {
+	ring := &fixedNodesRing{}
+	var servers []*httptest.Server
+	for i := 0; i < 3; i++ {
+		ts := httptest.NewServer(handler)
+		servers = append(servers, ts)
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+		require.Nil(t, err)
+		portNum, err := strconv.Atoi(port)
+		require.Nil(t, err)
+		ring.nodes = append(ring.nodes, &hummingbird.Device{Ip: host, Port: portNum, Device: "sda"})
+	}
+	return ring, func() {
+		for _, ts := range servers {
+			ts.Close()
+		}
+	}
+}
+
+func TestPutObjectRejectedBeforeBody(t *testing.T) {
+	ring, done := putBackends(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+	})
+	defer done()
+	c := &ProxyDirectClient{client: &http.Client{Transport: newBackendTransport(hummingbird.Config{})}, ObjectRing: ring, ContainerRing: ring}
+	src := &readRecorder{Reader: strings.NewReader("some data")}
+	require.Equal(t, http.StatusRequestEntityTooLarge, c.PutObject("a", "c", "o", http.Header{}, src))
+	require.False(t, src.read)
+}
+
+func TestPutObjectSendsBodyAfterContinue(t *testing.T) {
+	var lock sync.Mutex
+	bodies := []string{}
+	ring, done := putBackends(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		lock.Lock()
+		bodies = append(bodies, string(body))
+		lock.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer done()
+	c := &ProxyDirectClient{client: &http.Client{Transport: newBackendTransport(hummingbird.Config{})}, ObjectRing: ring, ContainerRing: ring}
+	src := &readRecorder{Reader: strings.NewReader("some data")}
+	require.Equal(t, http.StatusCreated, c.PutObject("a", "c", "o", http.Header{}, src))
+	require.True(t, src.read)
+	lock.Lock()
+	defer lock.Unlock()
+	for _, body := range bodies {
+		require.Equal(t, "some data", body)
+	}
+	require.True(t, len(bodies) >= 2)
+}
+
+func TestPutObjectOneBackendRejects(t *testing.T) {
+	var lock sync.Mutex
+	rejected := false
+	ring, done := putBackends(t, func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		reject := !rejected
+		rejected = true
+		lock.Unlock()
+		if reject {
+			w.WriteHeader(507)
+			return
+		}
+		io.Copy(ioutil.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	})
+	defer done()
+	c := &ProxyDirectClient{client: &http.Client{Transport: newBackendTransport(hummingbird.Config{})}, ObjectRing: ring, ContainerRing: ring}
+	status := make(chan int)
+	go func() {
+		status <- c.PutObject("a", "c", "o", http.Header{}, bytes.NewReader(bytes.Repeat([]byte("x"), 1024*1024)))
+	}()
+	select {
+	case s := <-status:
+		require.Equal(t, http.StatusCreated, s)
+	case <-time.After(10 * time.Second):
+		t.Fatal("PutObject hung on the rejecting backend")
+	}
+}
@@ -0,0 +1,147 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+type segmentResult struct {
+	data []byte
+	err  error
+}
+
+// parallelGetReader reassembles an object from byte ranges fetched concurrently from several backend nodes.
+type parallelGetReader struct {
+	*io.PipeReader
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (p *parallelGetReader) Close() error {
+	p.closeOnce.Do(func() { close(p.done) })
+	return p.PipeReader.Close()
+}
+
+// fetchSegment GETs bytes [start, end) of the object, trying each node in turn starting with the one at index first.
+func (c *ProxyDirectClient) fetchSegment(reqs []*http.Request, first int, etag string, start, end int64, done chan struct{}) ([]byte, error) {
+	var lastErr error
+	for i := range reqs {
+		select {
+		case <-done:
+			return nil, fmt.Errorf("Canceled")
+		default:
+		}
+		orig := reqs[(first+i)%len(reqs)]
+		req, err := http.NewRequest("GET", orig.URL.String(), nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for key := range orig.Header {
+			req.Header.Set(key, orig.Header.Get(key))
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end-1))
+		if etag != "" {
+			req.Header.Set("If-Match", etag)
+		}
+		req.Cancel = done
+		resp, err := c.client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+		} else if resp.StatusCode != http.StatusPartialContent {
+			lastErr = fmt.Errorf("Range GET to %s returned %d", req.URL.Host, resp.StatusCode)
+		} else if int64(len(data)) != end-start {
+			lastErr = fmt.Errorf("Range GET to %s returned %d of %d bytes", req.URL.Host, len(data), end-start)
+		} else {
+			return data, nil
+		}
+	}
+	return nil, lastErr
+}
+
+// parallelGet streams the object in first, reading its first segment from first.Body and fetching the rest as byte
+// ranges spread across the nodes in reqs.  At most one segment per node is buffered at a time.  The object must be
+// larger than a single segment.
+func (c *ProxyDirectClient) parallelGet(first *http.Response, reqs []*http.Request) io.ReadCloser {
+	size := first.ContentLength
+	segmentSize := c.parallelGetSegmentSize
+	segments := int((size + segmentSize - 1) / segmentSize)
+	etag := first.Header.Get("Etag")
+	pr, pw := io.Pipe()
+	reader := &parallelGetReader{PipeReader: pr, done: make(chan struct{})}
+
+	results := make([]chan segmentResult, segments)
+	for i := range results {
+		results[i] = make(chan segmentResult, 1)
+	}
+	sem := make(chan struct{}, len(reqs))
+	go func() {
+		for i := 1; i < segments; i++ {
+			select {
+			case sem <- struct{}{}:
+			case <-reader.done:
+				return
+			}
+			start := int64(i) * segmentSize
+			end := start + segmentSize
+			if end > size {
+				end = size
+			}
+			go func(i int, start, end int64) {
+				data, err := c.fetchSegment(reqs, i, etag, start, end, reader.done)
+				results[i] <- segmentResult{data, err}
+			}(i, start, end)
+		}
+	}()
+
+	go func() {
+		_, err := io.CopyN(pw, first.Body, segmentSize)
+		first.Body.Close()
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		for i := 1; i < segments; i++ {
+			var result segmentResult
+			select {
+			case result = <-results[i]:
+			case <-reader.done:
+				return
+			}
+			if result.err != nil {
+				pw.CloseWithError(result.err)
+				return
+			}
+			if _, err := pw.Write(result.data); err != nil {
+				return
+			}
+			<-sem
+		}
+		pw.Close()
+	}()
+	return reader
+}
@@ -0,0 +1,138 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/troubling/hummingbird/hummingbird"
+
+	"github.com/stretchr/testify/require"
+)
+
+type fixedNodesRing struct {
+	hummingbird.Ring
+	nodes []*hummingbird.Device
+}
+
+func (r *fixedNodesRing) GetPartition(account string, container string, object string) uint64 {
+	return 0
+}
+
+func (r *fixedNodesRing) GetNodes(partition uint64) []*hummingbird.Device {
+	return r.nodes
+}
+
+// objectNodes starts a backend per node that serves body, failing ranged requests on any node marked broken.
+func objectNodes(t *testing.T, body []byte, broken ...bool) (*fixedNodesRing, map[string][]string, func()) {
+	var lock sync.Mutex
+	ranges := make(map[string][]string)
+	ring := &fixedNodesRing{}
+	var servers []*httptest.Server
+	for i := range broken {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if rng := r.Header.Get("Range"); rng != "" {
+				lock.Lock()
+				ranges[r.Host] = append(ranges[r.Host], rng)
+				lock.Unlock()
+				if broken[i] {
+					w.WriteHeader(500)
+					return
+				}
+				if r.Header.Get("If-Match") != "theetag" {
+					w.WriteHeader(412)
+					return
+				}
+				r.Header.Del("If-Match")
+			}
+			w.Header().Set("Etag", "theetag")
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+		}))
+		servers = append(servers, ts)
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(ts.URL, "http://"))
+		require.Nil(t, err)
+		portNum, err := strconv.Atoi(port)
+		require.Nil(t, err)
+		ring.nodes = append(ring.nodes, &hummingbird.Device{Ip: host, Port: portNum, Device: "sda"})
+	}
+	return ring, ranges, func() {
+		for _, ts := range servers {
+			ts.Close()
+		}
+	}
+}
+
+func TestParallelGet(t *testing.T) {
+	body := make([]byte, 1000)
+	for i := range body {
+		body[i] = byte(i % 251)
+	}
+	ring, ranges, done := objectNodes(t, body, false, false, false)
+	defer done()
+	c := &ProxyDirectClient{client: &http.Client{}, ObjectRing: ring, parallelGetThreshold: 500, parallelGetSegmentSize: 100}
+	r, headers, code := c.GetObject("a", "c", "o", http.Header{})
+	require.Equal(t, 200, code)
+	require.Equal(t, "1000", headers.Get("Content-Length"))
+	data, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	r.Close()
+	require.Equal(t, body, data)
+
+	total := 0
+	for _, rngs := range ranges {
+		total += len(rngs)
+	}
+	require.Equal(t, 9, total)
+	require.True(t, len(ranges) > 1)
+}
+
+func TestParallelGetRetriesOtherNodes(t *testing.T) {
+	body := []byte(strings.Repeat("abcdefghij", 50))
+	ring, ranges, done := objectNodes(t, body, false, true, false)
+	defer done()
+	c := &ProxyDirectClient{client: &http.Client{}, ObjectRing: ring, parallelGetThreshold: 1, parallelGetSegmentSize: 64}
+	r, _, code := c.GetObject("a", "c", "o", http.Header{})
+	require.Equal(t, 200, code)
+	data, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	r.Close()
+	require.Equal(t, body, data)
+	require.Equal(t, 3, len(ranges))
+}
+
+func TestParallelGetBelowThreshold(t *testing.T) {
+	body := []byte(strings.Repeat("x", 300))
+	ring, ranges, done := objectNodes(t, body, false, false, false)
+	defer done()
+	c := &ProxyDirectClient{client: &http.Client{}, ObjectRing: ring, parallelGetThreshold: 500, parallelGetSegmentSize: 100}
+	r, _, code := c.GetObject("a", "c", "o", http.Header{})
+	require.Equal(t, 200, code)
+	data, err := ioutil.ReadAll(r)
+	require.Nil(t, err)
+	r.Close()
+	require.Equal(t, body, data)
+	require.Equal(t, 0, len(ranges))
+}
@@ -141,7 +141,8 @@ func StopServer(name string, args ...string) {
 		fmt.Println("Error finding", name, "server process:", err)
 		return
 	}
-	process.Signal(syscall.SIGTERM)
+	// SIGTERM drains in-flight requests, so use SIGQUIT to stop right away.
+	process.Signal(syscall.SIGQUIT)
 	process.Wait()
 	RemovePid(name)
 	fmt.Println(strings.Title(name), "server stopped.")
@@ -150,7 +151,7 @@ func StopServer(name string, args ...string) {
 func RestartServer(name string, args ...string) {
 	process, err := GetProcess(name)
 	if err == nil {
-		process.Signal(syscall.SIGTERM)
+		process.Signal(syscall.SIGQUIT)
 		process.Wait()
 		fmt.Println(strings.Title(name), "server stopped.")
 	} else {
@@ -246,6 +247,7 @@ func main() {
 	objectReplicatorFlags.Bool("once", false, "Run one pass of the replicator")
 	objectReplicatorFlags.String("devices", "", "Replicate only given devices. Comma-separated list.")
 	objectReplicatorFlags.String("partitions", "", "Replicate only given partitions. Comma-separated list.")
+	objectReplicatorFlags.Bool("dry-run", false, "Report what would be replicated without sending or deleting any files. Recalculating suffix hashes still reclaims tombstones older than reclaim_age and removes files superseded by newer ones, as it does on any object server.")
 	objectReplicatorFlags.Usage = func() {
 		fmt.Fprintf(os.Stderr, "hummingbird object-replicator [ARGS]\n")
 		fmt.Fprintf(os.Stderr, "  Run object replicator\n")
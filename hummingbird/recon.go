@@ -278,6 +278,39 @@ func quarantineCounts(driveRoot string) (map[string]interface{}, error) {
 	return qcounts, nil
 }
 
+// asyncPendingAge returns the age in seconds of the oldest async pending container update on each device.
+func asyncPendingAge(driveRoot string) (map[string]interface{}, error) {
+	ages := make(map[string]interface{})
+	deviceList, err := ioutil.ReadDir(driveRoot)
+	if err != nil {
+		return nil, err
+	}
+	now := float64(time.Now().UnixNano()) / 1e9
+	for _, info := range deviceList {
+		var oldest float64
+		suffixes, _ := filepath.Glob(filepath.Join(driveRoot, info.Name(), "async_pending", "[0-9a-f][0-9a-f][0-9a-f]"))
+		for _, suffix := range suffixes {
+			files, _ := ioutil.ReadDir(suffix)
+			for _, file := range files {
+				name := file.Name()
+				timestamp, err := strconv.ParseFloat(name[strings.LastIndex(name, "-")+1:], 64)
+				if err != nil {
+					timestamp = float64(file.ModTime().UnixNano()) / 1e9
+				}
+				if oldest == 0 || timestamp < oldest {
+					oldest = timestamp
+				}
+			}
+		}
+		if oldest == 0 {
+			ages[info.Name()] = 0
+		} else {
+			ages[info.Name()] = now - oldest
+		}
+	}
+	return ages, nil
+}
+
 func diskUsage(driveRoot string) ([]map[string]interface{}, error) {
 	devices := make([]map[string]interface{}, 0)
 	dirInfo, err := os.Stat(driveRoot)
@@ -324,6 +357,13 @@ func ReconHandler(driveRoot string, writer http.ResponseWriter, request *http.Re
 			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 			return
 		}
+	case "async_age":
+		var err error
+		content, err = asyncPendingAge(driveRoot)
+		if err != nil {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 	case "replication":
 		var err error
 		if vars["recon_type"] == "account" {
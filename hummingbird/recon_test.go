@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -179,3 +180,26 @@ func TestGetLoad(t *testing.T) {
 	require.True(t, ok)
 	require.True(t, m5f > 0.0)
 }
+
+func TestAsyncPendingAge(t *testing.T) {
+	driveRoot, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(driveRoot)
+	old := time.Now().Add(-2 * time.Hour)
+	newer := time.Now().Add(-10 * time.Minute)
+	for _, ts := range []time.Time{newer, old} {
+		name := "00000000000000000000000000000abc-" + CanonicalTimestamp(float64(ts.UnixNano())/1e9)
+		require.Nil(t, os.MkdirAll(filepath.Join(driveRoot, "sda", "async_pending", "abc"), 0755))
+		require.Nil(t, ioutil.WriteFile(filepath.Join(driveRoot, "sda", "async_pending", "abc", name), []byte{}, 0644))
+	}
+	require.Nil(t, os.MkdirAll(filepath.Join(driveRoot, "sdb"), 0755))
+
+	r, _ := http.NewRequest("GET", "/recon/async_age", nil)
+	r = SetVars(r, map[string]string{"method": "async_age"})
+	w := &testWriter{make(http.Header), bytes.NewBuffer(nil), 0}
+	ReconHandler(driveRoot, w, r)
+	var ages map[string]float64
+	require.Nil(t, json.Unmarshal(w.f.Bytes(), &ages))
+	require.InDelta(t, 7200, ages["sda"], 5)
+	require.Equal(t, float64(0), ages["sdb"])
+}
@@ -27,6 +27,7 @@ import (
 	"os/signal"
 	"runtime/debug"
 	"strconv"
+	"sync"
 	"syscall"
 	"time"
 	"unicode/utf8"
@@ -148,6 +149,10 @@ func (r RequestLogger) LogDebug(format string, args ...interface{}) {
 
 func (r RequestLogger) LogPanics(msg string) {
 	if e := recover(); e != nil {
+		// handlers abort a response they can't finish this way, so net/http can drop the connection.
+		if e == http.ErrAbortHandler {
+			panic(e)
+		}
 		transactionId := r.Request.Header.Get("X-Trans-Id")
 		r.Logger.Err(fmt.Sprintf("PANIC (%s): %s: %s", msg, e, debug.Stack()) + " (txn:" + transactionId + ")")
 		// if we haven't set a status code yet, we can send a 500 response.
@@ -178,8 +183,25 @@ type LowLevelLogger interface {
 
 type HummingbirdServer struct {
 	http.Server
-	Listener net.Listener
-	logger   LowLevelLogger
+	Listener     net.Listener
+	logger       LowLevelLogger
+	server       Server
+	drainTimeout time.Duration
+}
+
+// drain stops accepting connections and waits up to drainTimeout for in-flight requests, force-closing any that remain.
+func (srv *HummingbirdServer) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), srv.drainTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.logger.Err(fmt.Sprintf("Error while graceful shutdown: %v", err))
+		if err := srv.Close(); err != nil {
+			srv.logger.Err(fmt.Sprintf("Error shutdown: %v", err))
+		}
+	}
+	if stopper, ok := srv.server.(Stopper); ok {
+		stopper.Stop()
+	}
 }
 
 func ShutdownStdio() {
@@ -210,12 +232,29 @@ type Server interface {
 	GetHandler(Config) http.Handler
 }
 
+// Stopper is implemented by servers with background loops that should be canceled at shutdown.
+type Stopper interface {
+	Stop()
+}
+
+// setServerTimeouts applies the [DEFAULT] read_header_timeout, read_timeout, write_timeout and idle_timeout settings (in seconds) to the server.
+func setServerTimeouts(srv *http.Server, config Config) {
+	timeout := func(key string, dfl float64) time.Duration {
+		return time.Duration(config.GetFloat("DEFAULT", key, dfl) * float64(time.Second))
+	}
+	srv.ReadHeaderTimeout = timeout("read_header_timeout", 60)
+	srv.ReadTimeout = timeout("read_timeout", 86400)
+	srv.WriteTimeout = timeout("write_timeout", 86400)
+	srv.IdleTimeout = timeout("idle_timeout", 60)
+}
+
 /*
-	SIGINT - graceful shutdown
-	SIGTERM, SIGQUIT - immediate shutdown
+	SIGINT, SIGTERM - graceful shutdown
+	SIGQUIT - immediate shutdown
 	SIGABRT - dump goroutines stacktrace
 
-	Graceful shutdown/restart gives any open connections 5 minutes to complete, then exits.
+	Graceful shutdown/restart gives any open connections [DEFAULT] drain_timeout
+	seconds (5 minutes by default) to complete, then force-closes them and exits.
 */
 func RunServers(GetServer func(Config, *flag.FlagSet) (string, int, Server, LowLevelLogger, error), flags *flag.FlagSet) {
 	var servers []*HummingbirdServer
@@ -245,13 +284,14 @@ func RunServers(GetServer func(Config, *flag.FlagSet) (string, int, Server, LowL
 		}
 		srv := HummingbirdServer{
 			Server: http.Server{
-				Handler:      server.GetHandler(config),
-				ReadTimeout:  24 * time.Hour,
-				WriteTimeout: 24 * time.Hour,
+				Handler: server.GetHandler(config),
 			},
-			Listener: sock,
-			logger:   logger,
+			Listener:     sock,
+			logger:       logger,
+			server:       server,
+			drainTimeout: time.Duration(config.GetFloat("DEFAULT", "drain_timeout", 300) * float64(time.Second)),
 		}
+		setServerTimeouts(&srv.Server, config)
 		go srv.Serve(sock)
 		servers = append(servers, &srv)
 		logger.Err(fmt.Sprintf("Server started on port %d", port))
@@ -265,15 +305,16 @@ func RunServers(GetServer func(Config, *flag.FlagSet) (string, int, Server, LowL
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGABRT)
 		s := <-c
-		if s == syscall.SIGINT {
+		if s == syscall.SIGINT || s == syscall.SIGTERM {
+			var wg sync.WaitGroup
 			for _, srv := range servers {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
-				defer cancel()
-				if err := srv.Shutdown(ctx); err != nil {
-					// failure/timeout shutting down the server gracefully
-					srv.logger.Err(fmt.Sprintf("Error while graceful shutdown: %v", err))
-				}
+				wg.Add(1)
+				go func(srv *HummingbirdServer) {
+					defer wg.Done()
+					srv.drain()
+				}(srv)
 			}
+			wg.Wait()
 		} else if s == syscall.SIGABRT {
 			pid := os.Getpid()
 			DumpGoroutinesStackTrace(pid)
@@ -0,0 +1,150 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package hummingbird
+
+import (
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetServerTimeouts(t *testing.T) {
+	config, err := StringConfig("[DEFAULT]\nread_header_timeout=1.5\nidle_timeout=30\n[app:object-server]\n")
+	require.Nil(t, err)
+	srv := &http.Server{}
+	setServerTimeouts(srv, config)
+	require.Equal(t, 1500*time.Millisecond, srv.ReadHeaderTimeout)
+	require.Equal(t, 24*time.Hour, srv.ReadTimeout)
+	require.Equal(t, 24*time.Hour, srv.WriteTimeout)
+	require.Equal(t, 30*time.Second, srv.IdleTimeout)
+}
+
+func TestSlowHeadersDisconnected(t *testing.T) {
+	config, err := StringConfig("[DEFAULT]\nread_header_timeout=0.1\n")
+	require.Nil(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	setServerTimeouts(srv, config)
+	sock, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	go srv.Serve(sock)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", sock.Addr().String())
+	require.Nil(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n"))
+	require.Nil(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	buf := make([]byte, 1024)
+	for err == nil {
+		_, err = conn.Read(buf)
+	}
+	require.True(t, time.Since(start) < 5*time.Second)
+	if nerr, ok := err.(net.Error); ok {
+		require.False(t, nerr.Timeout())
+	}
+}
+
+type stopperServer struct {
+	stopped bool
+}
+
+func (s *stopperServer) GetHandler(config Config) http.Handler {
+	return nil
+}
+
+func (s *stopperServer) Stop() {
+	s.stopped = true
+}
+
+func startDrainServer(t *testing.T, handler http.HandlerFunc, drainTimeout time.Duration) (*HummingbirdServer, *stopperServer) {
+	sock, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	background := &stopperServer{}
+	srv := &HummingbirdServer{
+		Server:       http.Server{Handler: handler},
+		Listener:     sock,
+		logger:       &consoleLogger{},
+		server:       background,
+		drainTimeout: drainTimeout,
+	}
+	go srv.Serve(sock)
+	return srv, background
+}
+
+func TestDrainFinishesInFlight(t *testing.T) {
+	started := make(chan bool)
+	srv, background := startDrainServer(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("done"))
+	}, 5*time.Second)
+	result := make(chan error)
+	go func() {
+		resp, err := http.Get("http://" + srv.Listener.Addr().String() + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		result <- err
+	}()
+	<-started
+	srv.drain()
+	require.Nil(t, <-result)
+	require.True(t, background.stopped)
+	_, err := http.Get("http://" + srv.Listener.Addr().String() + "/")
+	require.NotNil(t, err)
+}
+
+func TestDrainTimeoutForceCloses(t *testing.T) {
+	started := make(chan bool)
+	release := make(chan bool)
+	defer close(release)
+	srv, background := startDrainServer(t, func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		<-release
+	}, 100*time.Millisecond)
+	result := make(chan error)
+	go func() {
+		resp, err := http.Get("http://" + srv.Listener.Addr().String() + "/")
+		if err == nil {
+			resp.Body.Close()
+		}
+		result <- err
+	}()
+	<-started
+	start := time.Now()
+	srv.drain()
+	require.True(t, time.Since(start) < 2*time.Second)
+	require.NotNil(t, <-result)
+	require.True(t, background.stopped)
+}
+
+func TestLogPanicsRepanicsAbortHandler(t *testing.T) {
+	request, err := http.NewRequest("GET", "/", nil)
+	require.Nil(t, err)
+	logger := RequestLogger{Request: request, Logger: &consoleLogger{}}
+	defer func() {
+		require.Equal(t, http.ErrAbortHandler, recover())
+	}()
+	func() {
+		defer logger.LogPanics("TEST")
+		panic(http.ErrAbortHandler)
+	}()
+}
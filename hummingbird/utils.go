@@ -502,6 +502,222 @@ func NewKeyedLimit(limitPerKey int64, totalLimit int64) *KeyedLimit {
 	return &KeyedLimit{limitPerKey: limitPerKey, totalLimit: totalLimit, locked: make(map[string]bool), inUse: make(map[string]int64)}
 }
 
+type tokenBucket struct {
+	tokens float64
+	rate   int64
+	last   time.Time
+}
+
+// full reports whether the bucket will have refilled by now, so it's no different from a new one.
+func (b *tokenBucket) full(now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*float64(b.rate) >= float64(b.rate)
+}
+
+// KeyedBandwidth shares a bytes per second budget between all of a key's transfers, allowing bursts of up to a second's worth.
+type KeyedBandwidth struct {
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+// Wait blocks until n more bytes can be sent for key without going over rate bytes per second.
+func (k *KeyedBandwidth) Wait(key string, rate int64, n int) {
+	if rate <= 0 || n <= 0 {
+		return
+	}
+	now := time.Now()
+	k.lock.Lock()
+	// buckets that have refilled are dropped, so keys that have gone idle don't pile up.
+	if now.Sub(k.lastPrune) > time.Second {
+		for bkey, b := range k.buckets {
+			if b.full(now) {
+				delete(k.buckets, bkey)
+			}
+		}
+		k.lastPrune = now
+	}
+	b, ok := k.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(rate), last: now}
+		k.buckets[key] = b
+	}
+	b.rate = rate
+	b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	wait := time.Duration(0)
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+	}
+	k.lock.Unlock()
+	time.Sleep(wait)
+}
+
+func NewKeyedBandwidth() *KeyedBandwidth {
+	return &KeyedBandwidth{buckets: make(map[string]*tokenBucket), lastPrune: time.Now()}
+}
+
+// p2Quantile estimates a quantile of a stream of samples with the P-square algorithm (Jain and Chlamtac, 1985), which
+// keeps five markers rather than the samples, so memory use and the cost of a read don't grow with the request count.
+type p2Quantile struct {
+	p       float64
+	count   int
+	heights [5]float64
+	pos     [5]float64
+	want    [5]float64
+	inc     [5]float64
+}
+
+func newP2Quantile(p float64) *p2Quantile {
+	return &p2Quantile{
+		p:    p,
+		pos:  [5]float64{1, 2, 3, 4, 5},
+		want: [5]float64{1, 1 + 2*p, 1 + 4*p, 3 + 2*p, 5},
+		inc:  [5]float64{0, p / 2, p, (1 + p) / 2, 1},
+	}
+}
+
+func (q *p2Quantile) add(x float64) {
+	if q.count < 5 {
+		q.heights[q.count] = x
+		q.count++
+		if q.count == 5 {
+			sort.Float64s(q.heights[:])
+		}
+		return
+	}
+	q.count++
+	k := 0
+	if x < q.heights[0] {
+		q.heights[0] = x
+	} else if x >= q.heights[4] {
+		q.heights[4] = x
+		k = 3
+	} else {
+		for x >= q.heights[k+1] {
+			k++
+		}
+	}
+	for i := k + 1; i < 5; i++ {
+		q.pos[i]++
+	}
+	for i := range q.want {
+		q.want[i] += q.inc[i]
+	}
+	for i := 1; i < 4; i++ {
+		d := q.want[i] - q.pos[i]
+		if (d >= 1 && q.pos[i+1]-q.pos[i] > 1) || (d <= -1 && q.pos[i-1]-q.pos[i] < -1) {
+			s := 1.0
+			if d < 0 {
+				s = -1.0
+			}
+			// move the marker along the parabola through its neighbours, or linearly if that would pass one of them.
+			h := q.heights[i] + s/(q.pos[i+1]-q.pos[i-1])*
+				((q.pos[i]-q.pos[i-1]+s)*(q.heights[i+1]-q.heights[i])/(q.pos[i+1]-q.pos[i])+
+					(q.pos[i+1]-q.pos[i]-s)*(q.heights[i]-q.heights[i-1])/(q.pos[i]-q.pos[i-1]))
+			if q.heights[i-1] < h && h < q.heights[i+1] {
+				q.heights[i] = h
+			} else {
+				j := i + int(s)
+				q.heights[i] += s * (q.heights[j] - q.heights[i]) / (q.pos[j] - q.pos[i])
+			}
+			q.pos[i] += s
+		}
+	}
+}
+
+func (q *p2Quantile) value() float64 {
+	if q.count == 0 {
+		return 0
+	}
+	if q.count < 5 {
+		sorted := make([]float64, q.count)
+		copy(sorted, q.heights[:q.count])
+		sort.Float64s(sorted)
+		i := int(q.p*float64(q.count)+0.5) - 1
+		if i < 0 {
+			i = 0
+		}
+		return sorted[i]
+	}
+	return q.heights[2]
+}
+
+// latencyEstimate holds the streaming p50, p95 and p99 estimates for one key and method.
+type latencyEstimate struct {
+	p50, p95, p99 *p2Quantile
+	count         int64
+}
+
+func newLatencyEstimate() *latencyEstimate {
+	return &latencyEstimate{p50: newP2Quantile(0.50), p95: newP2Quantile(0.95), p99: newP2Quantile(0.99)}
+}
+
+func (l *latencyEstimate) add(v float64) {
+	l.p50.add(v)
+	l.p95.add(v)
+	l.p99.add(v)
+	l.count++
+}
+
+func (l *latencyEstimate) percentiles() map[string]float64 {
+	return map[string]float64{"p50": l.p50.value(), "p95": l.p95.value(), "p99": l.p99.value(), "count": float64(l.count)}
+}
+
+// LatencyTracker tracks request latency percentiles per key (e.g. device) and method, over every request since the
+// server started.
+type LatencyTracker struct {
+	lock      sync.Mutex
+	estimates map[string]map[string]*latencyEstimate
+}
+
+// Observe records a request's latency for the key and method.
+func (l *LatencyTracker) Observe(key string, method string, latency time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	methods, ok := l.estimates[key]
+	if !ok {
+		methods = make(map[string]*latencyEstimate)
+		l.estimates[key] = methods
+	}
+	e, ok := methods[method]
+	if !ok {
+		e = newLatencyEstimate()
+		methods[method] = e
+	}
+	e.add(latency.Seconds())
+}
+
+// Percentiles returns the p50, p95 and p99 latencies in seconds, and the total request count, for the key and method.
+func (l *LatencyTracker) Percentiles(key string, method string) map[string]float64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if e, ok := l.estimates[key][method]; ok {
+		return e.percentiles()
+	}
+	return nil
+}
+
+func (l *LatencyTracker) MarshalJSON() ([]byte, error) {
+	l.lock.Lock()
+	report := make(map[string]map[string]map[string]float64)
+	for key, methods := range l.estimates {
+		report[key] = make(map[string]map[string]float64)
+		for method, e := range methods {
+			report[key][method] = e.percentiles()
+		}
+	}
+	l.lock.Unlock()
+	return json.Marshal(report)
+}
+
+func NewLatencyTracker() *LatencyTracker {
+	return &LatencyTracker{estimates: make(map[string]map[string]*latencyEstimate)}
+}
+
 var configLocations = []string{"/etc/hummingbird/hummingbird.conf", "/etc/swift/swift.conf"}
 
 // GetHashPrefixAndSuffix retrieves the hash path prefix and suffix from
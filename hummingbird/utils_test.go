@@ -18,6 +18,7 @@ package hummingbird
 import (
 	"bytes"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"testing"
 	"time"
@@ -322,3 +323,91 @@ func TestLockPath(t *testing.T) {
 	require.Nil(t, f)
 	require.NotNil(t, err)
 }
+
+func TestLatencyTracker(t *testing.T) {
+	l := NewLatencyTracker()
+	for _, i := range rand.Perm(10000) {
+		l.Observe("sda", "GET", time.Duration(i+1)*100*time.Microsecond)
+	}
+	p := l.Percentiles("sda", "GET")
+	require.NotNil(t, p)
+	assert.InDelta(t, 0.500, p["p50"], 0.01)
+	assert.InDelta(t, 0.950, p["p95"], 0.01)
+	assert.InDelta(t, 0.990, p["p99"], 0.01)
+	assert.Equal(t, float64(10000), p["count"])
+	assert.Nil(t, l.Percentiles("sda", "PUT"))
+	assert.Nil(t, l.Percentiles("sdb", "GET"))
+}
+
+func TestLatencyTrackerSkewed(t *testing.T) {
+	// 90% fast requests and a slow tail, as from a disk that's starting to struggle.
+	l := NewLatencyTracker()
+	for _, i := range rand.Perm(10000) {
+		if i < 9000 {
+			l.Observe("sda", "PUT", time.Duration(1+i%10)*time.Millisecond)
+		} else {
+			l.Observe("sda", "PUT", time.Duration(i-8999)*time.Millisecond)
+		}
+	}
+	p := l.Percentiles("sda", "PUT")
+	assert.InDelta(t, 0.006, p["p50"], 0.002)
+	assert.InDelta(t, 0.500, p["p95"], 0.02)
+	assert.InDelta(t, 0.900, p["p99"], 0.02)
+}
+
+func TestLatencyTrackerFewSamples(t *testing.T) {
+	l := NewLatencyTracker()
+	l.Observe("sda", "HEAD", 3*time.Millisecond)
+	l.Observe("sda", "HEAD", time.Millisecond)
+	l.Observe("sda", "HEAD", 2*time.Millisecond)
+	p := l.Percentiles("sda", "HEAD")
+	assert.Equal(t, 0.002, p["p50"])
+	assert.Equal(t, 0.003, p["p99"])
+	assert.Equal(t, float64(3), p["count"])
+}
+
+func TestKeyedBandwidth(t *testing.T) {
+	k := NewKeyedBandwidth()
+	start := time.Now()
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for j := 0; j < 15; j++ {
+				k.Wait("a", 100000, 5000)
+			}
+			done <- true
+		}()
+	}
+	<-done
+	<-done
+	// 150000 bytes at 100000/s, less the initial second's burst
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 450*time.Millisecond)
+	assert.True(t, elapsed < 5*time.Second)
+
+	start = time.Now()
+	k.Wait("b", 100000, 50000)
+	k.Wait("c", 0, 50000)
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestKeyedBandwidthPrunesIdleKeys(t *testing.T) {
+	k := NewKeyedBandwidth()
+	k.Wait("a", 100000, 110000)
+	k.Wait("b", 100000, 1000)
+	k.lock.Lock()
+	assert.Equal(t, 2, len(k.buckets))
+	// a second on, b has refilled but a is still paying off its burst.
+	k.lastPrune = time.Now().Add(-2 * time.Second)
+	k.buckets["b"].last = time.Now().Add(-time.Second)
+	k.buckets["a"].last = time.Now().Add(-time.Second)
+	k.lock.Unlock()
+	k.Wait("c", 100000, 1000)
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	_, hasA := k.buckets["a"]
+	_, hasB := k.buckets["b"]
+	assert.True(t, hasA)
+	assert.False(t, hasB)
+	assert.Equal(t, 2, len(k.buckets))
+}
@@ -18,12 +18,16 @@ package objectserver
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -33,6 +37,9 @@ import (
 // AuditForeverInterval represents how often a auditor check should be performed.
 var AuditForeverInterval = 30 * time.Second
 
+// strayMinAge is how old stray files and empty directories must be before fix_structure touches them, so in-progress writes are left alone.
+var strayMinAge = time.Hour
+
 // AuditorDaemon keeps track of object specific audit data.
 type AuditorDaemon struct {
 	checkMounts       bool
@@ -44,6 +51,12 @@ type AuditorDaemon struct {
 	regFilesPerSecond int64
 	zbFilesPerSecond  int64
 	reconCachePath    string
+	repairMetadata    bool
+	fixStructure      bool
+	rings             map[int]hummingbird.Ring
+	client            *http.Client
+	localIPs          map[string]bool
+	bindPort          int
 }
 
 // Auditor keeps track of general audit data.
@@ -75,12 +88,25 @@ func rateLimitSleep(startTime time.Time, done int64, rate int64) {
 	}
 }
 
+// requiredDataMetadata lists the metadata entries every .data file must have.
+var requiredDataMetadata = []string{"Content-Length", "Content-Type", "name", "ETag", "X-Timestamp"}
+
+func hasRequiredDataMetadata(metadata map[string]string) bool {
+	for _, reqEntry := range requiredDataMetadata {
+		if _, ok := metadata[reqEntry]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
 // auditHash of object hash dir.
 func auditHash(hashPath string, skipMd5 bool) (bytesProcessed int64, err error) {
 	objFiles, err := hummingbird.ReadDirNames(hashPath)
 	if err != nil {
 		return 0, fmt.Errorf("Error reading hash dir")
 	}
+	var dataMetadata, metaMetadata map[string]string
 	for _, file := range objFiles {
 		filePath := filepath.Join(hashPath, file)
 
@@ -100,7 +126,8 @@ func auditHash(hashPath string, skipMd5 bool) (bytesProcessed int64, err error)
 		}
 
 		if ext == ".data" {
-			for _, reqEntry := range []string{"Content-Length", "Content-Type", "name", "ETag", "X-Timestamp"} {
+			dataMetadata = metadata
+			for _, reqEntry := range requiredDataMetadata {
 				if _, ok := metadata[reqEntry]; !ok {
 					return bytesProcessed, fmt.Errorf("Required metadata entry %s not found", reqEntry)
 				}
@@ -133,11 +160,162 @@ func auditHash(hashPath string, skipMd5 bool) (bytesProcessed int64, err error)
 					return bytesProcessed, fmt.Errorf("Required metadata entry %s not found", reqEntry)
 				}
 			}
+		} else if ext == ".meta" {
+			metaMetadata = metadata
+		}
+	}
+	if dataMetadata != nil && metaMetadata != nil {
+		if err := checkMetaTimestamps(dataMetadata, metaMetadata); err != nil {
+			return bytesProcessed, fmt.Errorf("Inconsistent timestamps: %v", err)
 		}
 	}
 	return bytesProcessed, nil
 }
 
+// peerDataMetadata asks the other primaries for dataFile's partition what metadata they have stored for the same
+// .data file, returning the first copy that matches the local file's size and contents.
+func (a *Auditor) peerDataMetadata(dataFile string) (map[string]string, error) {
+	hashDir := filepath.Dir(dataFile)
+	suffixDir := filepath.Dir(hashDir)
+	partitionDir := filepath.Dir(suffixDir)
+	objectsDir := filepath.Dir(partitionDir)
+	device := filepath.Base(filepath.Dir(objectsDir))
+	policy := 0
+	if parts := strings.SplitN(filepath.Base(objectsDir), "-", 2); len(parts) == 2 {
+		var err error
+		if policy, err = strconv.Atoi(parts[1]); err != nil {
+			return nil, fmt.Errorf("Unable to determine policy of %s", objectsDir)
+		}
+	}
+	ring, ok := a.rings[policy]
+	if !ok {
+		return nil, fmt.Errorf("No ring for policy %d", policy)
+	}
+	partition, err := strconv.ParseUint(filepath.Base(partitionDir), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid partition %s", partitionDir)
+	}
+	finfo, err := os.Stat(dataFile)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(dataFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	h := md5.New()
+	if _, err := hummingbird.Copy(file, h); err != nil {
+		return nil, err
+	}
+	etag := hex.EncodeToString(h.Sum(nil))
+
+	timestamp := strings.TrimSuffix(filepath.Base(dataFile), ".data")
+	for _, node := range ring.GetNodes(partition) {
+		if node.Device == device && node.Port == a.bindPort && a.localIPs[node.Ip] {
+			continue
+		}
+		url := fmt.Sprintf("http://%s:%d/metadata/%s/%s/%s?timestamp=%s", node.Ip, node.Port, node.Device,
+			filepath.Base(partitionDir), filepath.Base(hashDir), timestamp)
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			continue
+		}
+		req.Header.Set("X-Backend-Storage-Policy-Index", strconv.Itoa(policy))
+		resp, err := a.client.Do(req)
+		if err != nil {
+			continue
+		}
+		var metadata map[string]string
+		err = json.NewDecoder(resp.Body).Decode(&metadata)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK {
+			continue
+		}
+		if metadata["ETag"] != etag || metadata["Content-Length"] != strconv.FormatInt(finfo.Size(), 10) {
+			a.LogError("Metadata for %s on %s/%s doesn't match local contents", dataFile, node.Ip, node.Device)
+			continue
+		}
+		return metadata, nil
+	}
+	return nil, fmt.Errorf("No matching metadata found on peers of %s/%d", device, partition)
+}
+
+// repairHashMetadata replaces unreadable or incomplete metadata on any .data files in hashDir with a copy from a peer.
+// It returns true if anything was repaired.
+func (a *Auditor) repairHashMetadata(hashDir string) bool {
+	if !a.repairMetadata {
+		return false
+	}
+	files, err := hummingbird.ReadDirNames(hashDir)
+	if err != nil {
+		return false
+	}
+	repaired := false
+	for _, file := range files {
+		if filepath.Ext(file) != ".data" {
+			continue
+		}
+		dataFile := filepath.Join(hashDir, file)
+		if metadata, err := ReadMetadata(dataFile); err == nil && hasRequiredDataMetadata(metadata) {
+			continue
+		}
+		metadata, err := a.peerDataMetadata(dataFile)
+		if err != nil {
+			a.LogError("Unable to repair metadata for %s: %v", dataFile, err)
+			continue
+		}
+		f, err := os.OpenFile(dataFile, os.O_RDWR, 0)
+		if err != nil {
+			continue
+		}
+		// Any chunks left over from the damaged copy follow the new pickle's STOP, so they're ignored when it's read back.
+		err = WriteMetadata(f.Fd(), metadata)
+		f.Close()
+		if err != nil {
+			a.LogError("Error writing repaired metadata to %s: %v", dataFile, err)
+			continue
+		}
+		a.LogInfo("Repaired metadata for %s from a peer", dataFile)
+		repaired = true
+	}
+	return repaired
+}
+
+// quarantineStray moves an entry that doesn't belong in the partition tree out of the way, if it's old enough.
+func (a *Auditor) quarantineStray(path string, finfo os.FileInfo, objsDir string) {
+	if time.Since(finfo.ModTime()) < strayMinAge {
+		return
+	}
+	quarantineDir := filepath.Join(filepath.Dir(objsDir), "quarantined", filepath.Base(objsDir))
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		a.LogError("Error making quarantine dir %s: %v", quarantineDir, err)
+		return
+	}
+	if err := os.Rename(path, filepath.Join(quarantineDir, filepath.Base(path)+"-"+hummingbird.UUID())); err != nil {
+		a.LogError("Error quarantining stray %s: %v", path, err)
+		return
+	}
+	a.LogError("Quarantined stray %s", path)
+	a.quarantines++
+	a.totalQuarantines++
+}
+
+// removeIfEmpty removes dir if it's empty and old enough, returning true if it was removed.
+func (a *Auditor) removeIfEmpty(dir string, finfo os.FileInfo) bool {
+	if time.Since(finfo.ModTime()) < strayMinAge {
+		return false
+	}
+	if names, err := hummingbird.ReadDirNames(dir); err != nil || len(names) != 0 {
+		return false
+	}
+	if err := os.Remove(dir); err != nil {
+		return false
+	}
+	a.LogInfo("Removed empty directory %s", dir)
+	return true
+}
+
 // auditSuffix directory.  Lists hash dirs, calls auditHash() for each, and quarantines any with errors.
 func (a *Auditor) auditSuffix(suffixDir string) {
 	hashes, err := hummingbird.ReadDirNames(suffixDir)
@@ -150,20 +328,37 @@ func (a *Auditor) auditSuffix(suffixDir string) {
 	for _, hash := range hashes {
 		_, hexErr := hex.DecodeString(hash)
 		hashDir := filepath.Join(suffixDir, hash)
-		if finfo, err := os.Stat(hashDir); err != nil || len(hash) != 32 || hexErr != nil || !finfo.Mode().IsDir() {
-			a.LogError("Skipping invalid file in suffix: %s", hashDir)
+		finfo, err := os.Stat(hashDir)
+		if err != nil || len(hash) != 32 || hexErr != nil || !finfo.Mode().IsDir() {
+			if err == nil && a.fixStructure {
+				a.quarantineStray(hashDir, finfo, filepath.Dir(filepath.Dir(suffixDir)))
+			} else {
+				a.LogError("Skipping invalid file in suffix: %s", hashDir)
+			}
+			continue
+		}
+		if a.fixStructure && a.removeIfEmpty(hashDir, finfo) {
+			InvalidateHash(hashDir)
 			continue
 		}
 		a.passes++
 		a.totalPasses++
 		bytesProcessed, err := auditHash(hashDir, a.auditorType == "ZBF")
+		if err != nil && a.repairHashMetadata(hashDir) {
+			var repairedBytes int64
+			repairedBytes, err = auditHash(hashDir, a.auditorType == "ZBF")
+			bytesProcessed += repairedBytes
+		}
 		a.bytesProcessed += bytesProcessed
 		a.totalBytes += bytesProcessed
 		rateLimitSleep(a.passStart, a.totalPasses, a.filesPerSecond)
 		rateLimitSleep(a.passStart, a.totalBytes, a.bytesPerSecond)
 		if err != nil {
+			if qerr := QuarantineHash(hashDir); qerr == QuarantineLimitError {
+				a.LogError("%s failed audit but can't be quarantined yet: %v", hashDir, err)
+				continue
+			}
 			a.LogError("%s failed audit and is being quarantined: %v", hashDir, err)
-			QuarantineHash(hashDir)
 			InvalidateHash(hashDir)
 			a.quarantines++
 			a.totalQuarantines++
@@ -182,15 +377,23 @@ func (a *Auditor) auditPartition(partitionDir string) {
 	}
 	for _, suffix := range suffixes {
 		suffixDir := filepath.Join(partitionDir, suffix)
-		if suffix == ".lock" || suffix == "hashes.pkl" {
+		if suffix == ".lock" || suffix == "hashes.pkl" || suffix == "hashes.invalid" {
 			continue
 		}
 		_, hexErr := strconv.ParseInt(suffix, 16, 64)
-		if finfo, err := os.Stat(suffixDir); err != nil || len(suffix) != 3 || hexErr != nil || !finfo.Mode().IsDir() {
-			a.LogError("Skipping invalid file in partition: %s", suffixDir)
+		finfo, err := os.Stat(suffixDir)
+		if err != nil || len(suffix) != 3 || hexErr != nil || !finfo.Mode().IsDir() {
+			if err == nil && a.fixStructure {
+				a.quarantineStray(suffixDir, finfo, filepath.Dir(partitionDir))
+			} else {
+				a.LogError("Skipping invalid file in partition: %s", suffixDir)
+			}
 			continue
 		}
 		a.auditSuffix(suffixDir)
+		if a.fixStructure {
+			a.removeIfEmpty(suffixDir, finfo)
+		}
 		if time.Since(a.lastLog) > (time.Duration(a.logTime) * time.Second) {
 			a.statsReport()
 		}
@@ -357,5 +560,40 @@ func NewAuditor(serverconf hummingbird.Config, flags *flag.FlagSet) (hummingbird
 	d.zbFilesPerSecond = serverconf.GetInt("object-auditor", "zero_byte_files_per_second", 50)
 	d.reconCachePath = serverconf.GetDefault("object-auditor", "recon_cache_path", "/var/cache/swift")
 	d.logTime = serverconf.GetInt("object-auditor", "log_time", 3600)
+	d.fixStructure = serverconf.GetBool("object-auditor", "fix_structure", false)
+	setQuarantineLimit(serverconf.GetInt("object-auditor", "quarantine_limit", 0),
+		time.Duration(serverconf.GetFloat("object-auditor", "quarantine_limit_interval", 3600)*float64(time.Second)),
+		d.logger, quarantineStats(serverconf, "object-auditor", "objectauditor"))
+	d.repairMetadata = serverconf.GetBool("object-auditor", "repair_metadata", false)
+	d.rings = make(map[int]hummingbird.Ring)
+	d.client = &http.Client{Timeout: time.Duration(serverconf.GetFloat("object-auditor", "repair_metadata_timeout", 10) * float64(time.Second))}
+	d.bindPort = int(serverconf.GetInt("app:object-server", "bind_port", 6000))
+	d.localIPs = make(map[string]bool)
+	if !d.repairMetadata {
+		return d, nil
+	}
+	// the local copy is the one being repaired, so it's skipped when asking the partition's primaries.
+	if localAddrs, err := net.InterfaceAddrs(); err != nil {
+		d.LogError("Unable to get local addresses: %v", err)
+	} else {
+		for _, addr := range localAddrs {
+			d.localIPs[strings.Split(addr.String(), "/")[0]] = true
+		}
+	}
+	hashPathPrefix, hashPathSuffix, err := hummingbird.GetHashPrefixAndSuffix()
+	if err != nil {
+		d.LogError("Unable to get hash prefix and suffix, metadata won't be repaired: %v", err)
+		return d, nil
+	}
+	for _, policy := range d.policies {
+		if policy.Type != "replication" {
+			continue
+		}
+		if ring, err := getRing("object", hashPathPrefix, hashPathSuffix, policy.Index); err != nil {
+			d.LogError("Unable to load ring for policy %d, metadata won't be repaired: %v", policy.Index, err)
+		} else {
+			d.rings[policy.Index] = ring
+		}
+	}
 	return d, nil
 }
@@ -19,8 +19,13 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -173,6 +178,28 @@ func TestAuditHashNoMetadata(t *testing.T) {
 	assert.Equal(t, bytesProcessed, int64(0))
 }
 
+func TestAuditHashMetaOlderThanData(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "fffffffffffffffffffffffffffffabc"), 0777)
+	f, _ := os.Create(filepath.Join(dir, "fffffffffffffffffffffffffffffabc", "12345.data"))
+	defer f.Close()
+	WriteMetadata(f.Fd(), map[string]string{"Content-Length": "12", "ETag": "d3ac5112fe464b81184352ccba743001", "name": "", "Content-Type": "", "X-Timestamp": "12345.00000"})
+	f.Write([]byte("testcontents"))
+	f, _ = os.Create(filepath.Join(dir, "fffffffffffffffffffffffffffffabc", "12346.meta"))
+	defer f.Close()
+	WriteMetadata(f.Fd(), map[string]string{"name": "", "X-Timestamp": "12344.00000"})
+	_, err := auditHash(filepath.Join(dir, "fffffffffffffffffffffffffffffabc"), false)
+	require.NotNil(t, err)
+	require.True(t, strings.Contains(err.Error(), "older than data file"))
+
+	f, _ = os.Create(filepath.Join(dir, "fffffffffffffffffffffffffffffabc", "12347.meta"))
+	defer f.Close()
+	WriteMetadata(f.Fd(), map[string]string{"name": "", "X-Timestamp": "12347.00000"})
+	_, err = auditHash(filepath.Join(dir, "fffffffffffffffffffffffffffffabc"), false)
+	require.Nil(t, err)
+}
+
 type auditLogSaver struct {
 	logged []string
 }
@@ -422,3 +449,149 @@ func TestStatReport(t *testing.T) {
 	assert.Equal(t, 1000.0, args[9])
 	assert.Equal(t, 120.0, args[10])
 }
+
+type peerRing struct {
+	hummingbird.Ring
+	nodes []*hummingbird.Device
+}
+
+func (r *peerRing) GetNodes(partition uint64) []*hummingbird.Device {
+	return r.nodes
+}
+
+// damagedLocalCopy PUTs an object to a peer object server and copies its .data file to a local device with the
+// given contents and unreadable metadata.  It returns the peer's .data file and the local copy.
+func damagedLocalCopy(t *testing.T, contents string) (string, *Auditor, string, func()) {
+	peer, err := makeObjectServer()
+	require.Nil(t, err)
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/1/a/c/o", peer.host, peer.port), strings.NewReader("testcontents"))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Length", "12")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+	peerHashDir := ObjHashDir(map[string]string{"device": "sda", "partition": "1", "account": "a", "container": "c", "obj": "o"},
+		peer.root, peer.objServer.hashPathPrefix, peer.objServer.hashPathSuffix, 0)
+	dataFile, _ := ObjectFiles(peerHashDir)
+
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	hashDir := filepath.Join(dir, "sdb", strings.TrimPrefix(peerHashDir, filepath.Join(peer.root, "sda")))
+	require.Nil(t, os.MkdirAll(hashDir, 0777))
+	localFile := filepath.Join(hashDir, filepath.Base(dataFile))
+	f, err := os.Create(localFile)
+	require.Nil(t, err)
+	f.Write([]byte(contents))
+	RawWriteMetadata(f.Fd(), []byte("not a pickle"))
+	f.Close()
+
+	auditor := makeAuditor("repair_metadata", "true")
+	auditor.rings = map[int]hummingbird.Ring{0: &peerRing{nodes: []*hummingbird.Device{{Ip: peer.host, Port: peer.port, Device: "sda"}}}}
+	return dataFile, auditor, localFile, func() {
+		peer.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAuditSuffixRepairsMetadataFromPeer(t *testing.T) {
+	peerFile, auditor, localFile, cleanup := damagedLocalCopy(t, "testcontents")
+	defer cleanup()
+	peerMetadata, err := ReadMetadata(peerFile)
+	require.Nil(t, err)
+
+	auditor.auditSuffix(filepath.Dir(filepath.Dir(localFile)))
+	assert.Equal(t, int64(0), auditor.totalQuarantines)
+	metadata, err := ReadMetadata(localFile)
+	require.Nil(t, err)
+	assert.Equal(t, peerMetadata, metadata)
+	assert.Equal(t, "d3ac5112fe464b81184352ccba743001", metadata["ETag"])
+}
+
+func TestAuditSuffixQuarantinesIfPeerMetadataDoesntMatch(t *testing.T) {
+	_, auditor, localFile, cleanup := damagedLocalCopy(t, "badcontents!")
+	defer cleanup()
+
+	auditor.auditSuffix(filepath.Dir(filepath.Dir(localFile)))
+	assert.Equal(t, int64(1), auditor.totalQuarantines)
+	_, err := os.Stat(localFile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPeerDataMetadataSkipsLocalDevice(t *testing.T) {
+	peerFile, auditor, localFile, cleanup := damagedLocalCopy(t, "testcontents")
+	defer cleanup()
+	peerMetadata, err := ReadMetadata(peerFile)
+	require.Nil(t, err)
+	asked := 0
+	local := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		asked++
+		w.WriteHeader(500)
+	}))
+	defer local.Close()
+	u, err := url.Parse(local.URL)
+	require.Nil(t, err)
+	host, port, err := net.SplitHostPort(u.Host)
+	require.Nil(t, err)
+	auditor.bindPort, err = strconv.Atoi(port)
+	require.Nil(t, err)
+	auditor.localIPs = map[string]bool{host: true}
+	ring := auditor.rings[0].(*peerRing)
+	ring.nodes = append([]*hummingbird.Device{{Ip: host, Port: auditor.bindPort, Device: "sdb"}}, ring.nodes...)
+
+	metadata, err := auditor.peerDataMetadata(localFile)
+	require.Nil(t, err)
+	assert.Equal(t, peerMetadata, metadata)
+	assert.Equal(t, 0, asked)
+}
+
+func TestRepairMetadataDefaultsOff(t *testing.T) {
+	_, auditor, localFile, cleanup := damagedLocalCopy(t, "testcontents")
+	defer cleanup()
+	auditor.repairMetadata = makeAuditor().repairMetadata
+	assert.False(t, auditor.repairHashMetadata(filepath.Dir(localFile)))
+}
+
+func TestAuditPartitionFixStructure(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(dir)
+	partitionDir := filepath.Join(dir, "sda", "objects", "1")
+	old := time.Now().Add(-2 * strayMinAge)
+	mkdir := func(path string) {
+		require.Nil(t, os.MkdirAll(path, 0777))
+		require.Nil(t, os.Chtimes(path, old, old))
+	}
+	touch := func(path string, age time.Time) {
+		require.Nil(t, ioutil.WriteFile(path, []byte("x"), 0666))
+		require.Nil(t, os.Chtimes(path, age, age))
+	}
+	mkdir(filepath.Join(partitionDir, "abc", "fffffffffffffffffffffffffffffabc"))
+	f, _ := os.Create(filepath.Join(partitionDir, "abc", "fffffffffffffffffffffffffffffabc", "12345.data"))
+	WriteMetadata(f.Fd(), map[string]string{"Content-Length": "12", "ETag": "d3ac5112fe464b81184352ccba743001", "name": "", "Content-Type": "", "X-Timestamp": ""})
+	f.Write([]byte("testcontents"))
+	f.Close()
+	mkdir(filepath.Join(partitionDir, "abc", "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeabc"))
+	touch(filepath.Join(partitionDir, "abc", "notahash"), old)
+	touch(filepath.Join(partitionDir, "abc", "fresh"), time.Now())
+	mkdir(filepath.Join(partitionDir, "def", "ddddddddddddddddddddddddddddddef"))
+	require.Nil(t, os.Chtimes(filepath.Join(partitionDir, "def"), old, old))
+	touch(filepath.Join(partitionDir, "junk"), old)
+	touch(filepath.Join(partitionDir, "hashes.invalid"), old)
+
+	auditor := makeAuditor("fix_structure", "true")
+	auditor.auditPartition(partitionDir)
+	assert.Equal(t, int64(2), auditor.totalQuarantines)
+
+	suffixes, err := hummingbird.ReadDirNames(partitionDir)
+	require.Nil(t, err)
+	assert.Equal(t, []string{".lock", "abc", "hashes.invalid"}, suffixes)
+	hashes, err := hummingbird.ReadDirNames(filepath.Join(partitionDir, "abc"))
+	require.Nil(t, err)
+	assert.Equal(t, []string{"fffffffffffffffffffffffffffffabc", "fresh"}, hashes)
+	quarantined, err := hummingbird.ReadDirNames(filepath.Join(dir, "sda", "quarantined", "objects"))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(quarantined))
+	assert.True(t, strings.HasPrefix(quarantined[0], "junk-"))
+	assert.True(t, strings.HasPrefix(quarantined[1], "notahash-"))
+}
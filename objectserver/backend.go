@@ -19,6 +19,7 @@ import (
 	"bufio"
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -28,8 +29,10 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/cactus/go-statsd-client/statsd"
 	"github.com/troubling/hummingbird/hummingbird"
 	"github.com/troubling/hummingbird/xattr"
 )
@@ -38,6 +41,7 @@ const METADATA_CHUNK_SIZE = 65536
 
 var LockPathError = errors.New("Error locking path")
 var PathNotDirError = errors.New("Path is not a directory")
+var QuarantineLimitError = errors.New("Quarantine limit reached")
 
 // AtomicFileWriter saves a new file atomically.
 type AtomicFileWriter interface {
@@ -49,8 +53,8 @@ type AtomicFileWriter interface {
 	Save(string) error
 	// Abandon removes any resources associated with this file.
 	Abandon() error
-	// Preallocate pre-allocates space on disk, given the expected file size and disk reserve size.
-	Preallocate(int64, int64) error
+	// Preallocate pre-allocates space on disk, given the expected file size, disk reserve size and free inode reserve.
+	Preallocate(int64, int64, int64) error
 }
 
 func PolicyDir(policy int) string {
@@ -149,12 +153,114 @@ func WriteMetadata(fd uintptr, v map[string]string) error {
 	return RawWriteMetadata(fd, hummingbird.PickleDumps(v))
 }
 
+// quarantineLimiter caps how many hash dirs each device quarantines per interval, so a failing disk or a bad check
+// can't move large numbers of objects aside, and set off a flood of repairs, all at once.
+type quarantineLimiter struct {
+	sync.Mutex
+	limit    int64
+	interval time.Duration
+	logger   hummingbird.LowLevelLogger
+	stats    quarantineCounter
+}
+
+// quarantineCounter is the part of a statsd client the limiter uses to count alerts.
+type quarantineCounter interface {
+	Inc(stat string, value int64, rate float32) error
+}
+
+// quarantineWindow is a device's count for the current interval.  It's kept on the device, so the object server,
+// replicator and auditor all count against the same limit instead of each getting their own.
+type quarantineWindow struct {
+	Start   float64 `json:"start"`
+	Count   int64   `json:"count"`
+	Alerted bool    `json:"alerted"`
+}
+
+var quarantineLimit = &quarantineLimiter{}
+
+// setQuarantineLimit allows each device at most limit quarantines per interval, or any number if limit is 0.
+// stats, if not nil, counts each time a device reaches the limit.
+func setQuarantineLimit(limit int64, interval time.Duration, logger hummingbird.LowLevelLogger, stats quarantineCounter) {
+	quarantineLimit.Lock()
+	defer quarantineLimit.Unlock()
+	quarantineLimit.limit = limit
+	quarantineLimit.interval = interval
+	quarantineLimit.logger = logger
+	quarantineLimit.stats = stats
+}
+
+// quarantineStats returns a statsd client for section's log_statsd_host, or nil if it doesn't have one.
+func quarantineStats(serverconf hummingbird.Config, section string, name string) quarantineCounter {
+	statsdHost := serverconf.GetDefault(section, "log_statsd_host", "")
+	if statsdHost == "" {
+		return nil
+	}
+	address := fmt.Sprintf("%s:%d", statsdHost, serverconf.GetInt(section, "log_statsd_port", 8125))
+	client, err := statsd.NewClient(address, serverconf.GetDefault(section, "log_statsd_metric_prefix", "")+".go."+name)
+	if err != nil {
+		return nil
+	}
+	return client
+}
+
+// allow counts a quarantine on driveDir, returning false and alerting once per interval if it's over the limit.
+func (q *quarantineLimiter) allow(driveDir string) (bool, error) {
+	q.Lock()
+	limit, interval, logger, stats := q.limit, q.interval, q.logger, q.stats
+	q.Unlock()
+	if limit <= 0 {
+		return true, nil
+	}
+	dir := filepath.Join(driveDir, "quarantined")
+	lock, err := hummingbird.LockPath(dir, 10*time.Second)
+	if err != nil {
+		return false, err
+	}
+	defer lock.Close()
+	windowFile := filepath.Join(dir, "limit_window")
+	var w quarantineWindow
+	if data, err := ioutil.ReadFile(windowFile); err == nil {
+		json.Unmarshal(data, &w)
+	}
+	now := float64(time.Now().UnixNano()) / 1e9
+	if now-w.Start >= interval.Seconds() || now < w.Start {
+		w = quarantineWindow{Start: now}
+	}
+	allowed := w.Count < limit
+	if allowed {
+		w.Count++
+	} else if w.Alerted {
+		return false, nil
+	} else {
+		if logger != nil {
+			logger.Err(fmt.Sprintf("Quarantine limit of %d per %v reached on %s, pausing quarantines", limit, interval, driveDir))
+		}
+		if stats != nil {
+			stats.Inc("quarantine_limit_reached", 1, 1.0)
+		}
+		w.Alerted = true
+	}
+	data, err := json.Marshal(w)
+	if err != nil {
+		return false, err
+	}
+	if err := hummingbird.WriteFileAtomic(windowFile, data, 0644); err != nil {
+		return false, err
+	}
+	return allowed, nil
+}
+
 func QuarantineHash(hashDir string) error {
 	// FYI- this does not invalidate the hash like swift's version. Please
 	// do that yourself
 	//          objects      partition    suffix       hash
 	objsDir := filepath.Dir(filepath.Dir(filepath.Dir(hashDir)))
 	driveDir := filepath.Dir(objsDir)
+	if allowed, err := quarantineLimit.allow(driveDir); err != nil {
+		return err
+	} else if !allowed {
+		return QuarantineLimitError
+	}
 	quarantineDir := filepath.Join(driveDir, "quarantined", filepath.Base(objsDir))
 	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
 		return err
@@ -395,9 +501,43 @@ func ObjectFiles(directory string) (string, string) {
 	return "", ""
 }
 
+// metadataTimestamp parses the epoch portion of a metadata X-Timestamp.
+func metadataTimestamp(metadata map[string]string) (float64, error) {
+	timestamp, ok := metadata["X-Timestamp"]
+	if !ok {
+		return 0, fmt.Errorf("Missing X-Timestamp")
+	}
+	epoch, err := strconv.ParseFloat(strings.Split(timestamp, "_")[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid X-Timestamp %q", timestamp)
+	}
+	return epoch, nil
+}
+
+// checkMetaTimestamps returns an error if the data file's timestamp is missing or a meta file is older than its data file.
+func checkMetaTimestamps(datafileMetadata map[string]string, metafileMetadata map[string]string) error {
+	dataTimestamp, err := metadataTimestamp(datafileMetadata)
+	if err != nil {
+		return fmt.Errorf("Data file: %v", err)
+	}
+	if metafileMetadata == nil {
+		return nil
+	}
+	metaTimestamp, err := metadataTimestamp(metafileMetadata)
+	if err != nil {
+		return fmt.Errorf("Meta file: %v", err)
+	}
+	if metaTimestamp < dataTimestamp {
+		return fmt.Errorf("Meta file timestamp %s is older than data file timestamp %s", metafileMetadata["X-Timestamp"], datafileMetadata["X-Timestamp"])
+	}
+	return nil
+}
+
 func applyMetaFile(metaFile string, datafileMetadata map[string]string) (map[string]string, error) {
 	if metadata, err := ReadMetadata(metaFile); err != nil {
 		return nil, err
+	} else if err := checkMetaTimestamps(datafileMetadata, metadata); err != nil {
+		return nil, err
 	} else {
 		for k, v := range datafileMetadata {
 			if k == "Content-Length" || k == "Content-Type" || k == "deleted" || k == "ETag" || strings.HasPrefix(k, "X-Object-Sysmeta-") {
@@ -415,6 +555,8 @@ func OpenObjectMetadata(fd uintptr, metaFile string) (map[string]string, error)
 	}
 	if metaFile != "" {
 		return applyMetaFile(metaFile, datafileMetadata)
+	} else if err := checkMetaTimestamps(datafileMetadata, nil); err != nil {
+		return nil, err
 	}
 	return datafileMetadata, nil
 }
@@ -426,6 +568,8 @@ func ObjectMetadata(dataFile string, metaFile string) (map[string]string, error)
 	}
 	if metaFile != "" {
 		return applyMetaFile(metaFile, datafileMetadata)
+	} else if err := checkMetaTimestamps(datafileMetadata, nil); err != nil {
+		return nil, err
 	}
 	return datafileMetadata, nil
 }
@@ -16,12 +16,14 @@
 package objectserver
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/troubling/hummingbird/hummingbird"
 
@@ -142,3 +144,52 @@ func TestQuarantineHash(t *testing.T) {
 	require.True(t, hummingbird.Exists(filepath.Join(driveRoot, "sdb", "quarantined", "objects-1")))
 	require.False(t, hummingbird.Exists(hashDir))
 }
+
+type quarantineCounts map[string]int64
+
+func (c quarantineCounts) Inc(stat string, value int64, rate float32) error {
+	c[stat] += value
+	return nil
+}
+
+func TestQuarantineHashLimit(t *testing.T) {
+	driveRoot, _ := ioutil.TempDir("", "")
+	defer os.RemoveAll(driveRoot)
+	logger := &auditLogSaver{}
+	counts := quarantineCounts{}
+	setQuarantineLimit(2, time.Hour, logger, counts)
+	defer setQuarantineLimit(0, 0, nil, nil)
+
+	hashDir := func(device, hash string) string {
+		dir := filepath.Join(driveRoot, device, "objects", "1", "abc", hash)
+		os.MkdirAll(dir, 0777)
+		return dir
+	}
+	require.Nil(t, QuarantineHash(hashDir("sda", "fffffffffffffffffffffffffffffabc")))
+	require.Nil(t, QuarantineHash(hashDir("sda", "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeabc")))
+	require.Equal(t, 0, len(logger.logged))
+
+	blocked := hashDir("sda", "dddddddddddddddddddddddddddddabc")
+	require.Equal(t, QuarantineLimitError, QuarantineHash(blocked))
+	require.Equal(t, QuarantineLimitError, QuarantineHash(blocked))
+	require.True(t, hummingbird.Exists(blocked))
+	require.Equal(t, 1, len(logger.logged))
+	require.True(t, strings.Contains(logger.logged[0], "Quarantine limit of 2"))
+	require.Equal(t, int64(1), counts["quarantine_limit_reached"])
+
+	// another daemon on the same device counts against the same limit, and doesn't alert again.
+	otherLogger := &auditLogSaver{}
+	setQuarantineLimit(2, time.Hour, otherLogger, counts)
+	require.Equal(t, QuarantineLimitError, QuarantineHash(blocked))
+	require.Equal(t, 0, len(otherLogger.logged))
+	require.Equal(t, int64(1), counts["quarantine_limit_reached"])
+
+	require.Nil(t, QuarantineHash(hashDir("sdb", "dddddddddddddddddddddddddddddabc")))
+
+	windowFile := filepath.Join(driveRoot, "sda", "quarantined", "limit_window")
+	data, err := json.Marshal(quarantineWindow{Start: float64(time.Now().Add(-2*time.Hour).UnixNano()) / 1e9, Count: 2, Alerted: true})
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(windowFile, data, 0644))
+	require.Nil(t, QuarantineHash(blocked))
+	require.False(t, hummingbird.Exists(blocked))
+}
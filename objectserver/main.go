@@ -16,8 +16,12 @@
 package objectserver
 
 import (
+	"bufio"
 	"crypto/md5"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -28,6 +32,8 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/justinas/alice"
@@ -46,10 +52,35 @@ type ObjectServer struct {
 	logLevel         string
 	diskInUse        *hummingbird.KeyedLimit
 	accountDiskInUse *hummingbird.KeyedLimit
+	deviceLatency    *hummingbird.LatencyTracker
+	maintenance      int32
+	maxObjectSize    map[int]int64
+	manifests        *manifestCache
+	postMode         string
+	jsonErrorBodies  bool
+	namingRules      map[string]*namingRule
+	bandwidthLimit   int64
+	accountBandwidth map[string]int64
+	bandwidth        *hummingbird.KeyedBandwidth
+	minUploadRate    float64
+	minUploadGrace   time.Duration
+	resumableUploads bool
+	uploadTimeout    time.Duration
+	reserve          int64
+	inodeReserve     int64
+	placementCheck   string
+	allowedHandoffs  int
+	objRings         map[int]hummingbird.Ring
+	readyRings       map[int]hummingbird.Ring
+	readyLock        sync.Mutex
+	localIPs         map[string]bool
+	bindPort         int
 	expiringDivisor  int64
 	updateClient     *http.Client
 	objEngines       map[int]ObjectEngine
 	updateTimeout    time.Duration
+	stop             chan struct{}
+	stopOnce         sync.Once
 }
 
 func (server *ObjectServer) newObject(req *http.Request, vars map[string]string, needData bool) (Object, error) {
@@ -64,6 +95,201 @@ func (server *ObjectServer) newObject(req *http.Request, vars map[string]string,
 	return engine.New(vars, needData)
 }
 
+// shapedReader holds reads to the account's bandwidth limit.
+type shapedReader struct {
+	io.Reader
+	bandwidth *hummingbird.KeyedBandwidth
+	key       string
+	rate      int64
+}
+
+func (r *shapedReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.bandwidth.Wait(r.key, r.rate, n)
+	return n, err
+}
+
+// shapedWriter holds writes to the account's bandwidth limit.
+type shapedWriter struct {
+	io.Writer
+	bandwidth *hummingbird.KeyedBandwidth
+	key       string
+	rate      int64
+}
+
+func (w *shapedWriter) Write(p []byte) (int, error) {
+	w.bandwidth.Wait(w.key, w.rate, len(p))
+	return w.Writer.Write(p)
+}
+
+// accountBandwidthLimit returns the bytes per second allowed for each direction of an account's transfers, or 0 for no limit.
+func (server *ObjectServer) accountBandwidthLimit(account string) int64 {
+	if limit, ok := server.accountBandwidth[account]; ok {
+		return limit
+	}
+	return server.bandwidthLimit
+}
+
+var errSlowUpload = errors.New("Upload slower than min_upload_rate")
+
+// slowUploadReader fails once the body has averaged fewer than minRate bytes per second for longer than grace.  Only
+// time spent waiting on the client counts, so holding the upload to an account's bandwidth limit doesn't look like a
+// slow client.  A client that stops sending altogether would leave Read blocked, so a watchdog also checks the rate
+// and hangs up on it.
+type slowUploadReader struct {
+	io.Reader
+	minRate  float64
+	grace    time.Duration
+	hangUp   func() bool
+	lock     sync.Mutex
+	read     int64
+	waited   time.Duration
+	reading  time.Time
+	watchdog *time.Timer
+	done     bool
+	hungUp   bool
+}
+
+func newSlowUploadReader(body io.Reader, minRate float64, grace time.Duration, hangUp func() bool) *slowUploadReader {
+	r := &slowUploadReader{Reader: body, minRate: minRate, grace: grace, hangUp: hangUp}
+	r.lock.Lock()
+	r.watchdog = time.AfterFunc(grace, r.check)
+	r.lock.Unlock()
+	return r
+}
+
+// elapsed is the time spent in reads so far, including one still in progress.  The lock must be held.
+func (r *slowUploadReader) elapsed() time.Duration {
+	if r.reading.IsZero() {
+		return r.waited
+	}
+	return r.waited + time.Since(r.reading)
+}
+
+// tooSlow reports whether the body has fallen behind minRate.  The lock must be held.
+func (r *slowUploadReader) tooSlow() bool {
+	elapsed := r.elapsed()
+	return elapsed > r.grace && float64(r.read)/elapsed.Seconds() < r.minRate
+}
+
+func (r *slowUploadReader) Read(p []byte) (int, error) {
+	r.lock.Lock()
+	r.reading = time.Now()
+	r.lock.Unlock()
+	n, err := r.Reader.Read(p)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.read += int64(n)
+	r.waited += time.Since(r.reading)
+	r.reading = time.Time{}
+	if r.hungUp || r.tooSlow() {
+		return n, errSlowUpload
+	}
+	return n, err
+}
+
+// check hangs up on the client if the body has fallen behind minRate, or checks again when it next could.
+// The lock is held while hanging up, so the handler can't finish and respond at the same time.
+func (r *slowUploadReader) check() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.done {
+		return
+	}
+	if !r.tooSlow() {
+		next := time.Duration(float64(r.read) / r.minRate * float64(time.Second))
+		if next < r.grace {
+			next = r.grace
+		}
+		r.watchdog.Reset(next - r.elapsed() + time.Millisecond)
+		return
+	}
+	r.done = true
+	r.hungUp = r.hangUp()
+}
+
+// stop ends the watchdog, and reports whether it hung up on the client, in which case there's nobody to respond to.
+func (r *slowUploadReader) stop() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.done = true
+	r.watchdog.Stop()
+	return r.hungUp
+}
+
+// hangUpSlowUpload returns a function that answers a stalled upload with a 408 and closes its connection out from
+// under the handler's blocked read.
+func hangUpSlowUpload(writer http.ResponseWriter) func() bool {
+	return func() bool {
+		hijacker, ok := writer.(http.Hijacker)
+		if !ok {
+			return false
+		}
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			return false
+		}
+		rw.WriteString("HTTP/1.1 408 Request Timeout\r\nConnection: close\r\nContent-Length: 0\r\n\r\n")
+		rw.Flush()
+		conn.Close()
+		return true
+	}
+}
+
+// objectRangeMD5 returns the base64 encoded MD5 of the object's bytes in [start, end), as used in Content-MD5.
+func objectRangeMD5(obj Object, start, end int64) (string, error) {
+	hash := md5.New()
+	if _, err := obj.CopyRange(hash, start, end); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
+
+// misplaced returns a reason if the ring says the object doesn't belong in the requested partition on this device, or "" if it does.
+func (server *ObjectServer) misplaced(policy int, vars map[string]string) string {
+	ring, ok := server.objRings[policy]
+	if !ok {
+		return ""
+	}
+	expected := ring.GetPartition(vars["account"], vars["container"], vars["obj"])
+	if partition, err := strconv.ParseUint(vars["partition"], 10, 64); err != nil || partition != expected {
+		return fmt.Sprintf("partition %s should be %d", vars["partition"], expected)
+	}
+	local := func(dev *hummingbird.Device) bool {
+		return dev.Device == vars["device"] && dev.Port == server.bindPort && server.localIPs[dev.Ip]
+	}
+	for _, dev := range ring.GetNodes(expected) {
+		if local(dev) {
+			return ""
+		}
+	}
+	if server.allowedHandoffs <= 0 {
+		return fmt.Sprintf("device %s is not a local primary for partition %d", vars["device"], expected)
+	}
+	more := ring.GetMoreNodes(expected)
+	for i := 0; i < server.allowedHandoffs; i++ {
+		dev := more.Next()
+		if dev == nil {
+			break
+		}
+		if local(dev) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("device %s is not a local primary or one of the first %d handoffs for partition %d", vars["device"], server.allowedHandoffs, expected)
+}
+
+// backendRequest reports whether the request comes from another backend service, going by its X-Backend-* headers,
+// rather than from a proxy on behalf of a client.
+func backendRequest(request *http.Request) bool {
+	for key := range request.Header {
+		if strings.HasPrefix(key, "X-Backend-") {
+			return true
+		}
+	}
+	return false
+}
+
 func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := hummingbird.GetVars(request)
 	headers := writer.Header()
@@ -80,6 +306,13 @@ func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *h
 			hummingbird.StandardResponse(writer, http.StatusPreconditionFailed)
 			return
 		} else {
+			if backendRequest(request) {
+				reason := "missing"
+				if obj.Deleted() {
+					reason = "deleted"
+				}
+				headers.Set("X-Backend-Not-Found-Reason", reason)
+			}
 			hummingbird.StandardResponse(writer, http.StatusNotFound)
 			return
 		}
@@ -90,6 +323,9 @@ func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *h
 	headers.Set("X-Backend-Timestamp", metadata["X-Timestamp"])
 	if deleteAt, ok := metadata["X-Delete-At"]; ok {
 		if deleteTime, err := hummingbird.ParseDate(deleteAt); err == nil && deleteTime.Before(time.Now()) {
+			if backendRequest(request) {
+				headers.Set("X-Backend-Not-Found-Reason", "expired")
+			}
 			hummingbird.StandardResponse(writer, http.StatusNotFound)
 			return
 		}
@@ -146,7 +382,14 @@ func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *h
 	headers.Set("Content-Type", metadata["Content-Type"])
 	headers.Set("Content-Length", metadata["Content-Length"])
 
+	var body io.Writer = writer
+	if rate := server.accountBandwidthLimit(vars["account"]); rate > 0 {
+		body = &shapedWriter{Writer: writer, bandwidth: server.bandwidth, key: vars["account"] + "/GET", rate: rate}
+	}
+
 	if rangeHeader := request.Header.Get("Range"); rangeHeader != "" {
+		// clients can ask for a Content-MD5 of each returned range, at the cost of reading it twice.
+		rangeMD5 := strings.ToLower(request.Header.Get("X-Range-Checksum")) == "md5" && request.Method == "GET"
 		ranges, err := hummingbird.ParseRange(rangeHeader, obj.ContentLength())
 		if err != nil {
 			headers.Set("Content-Length", "0")
@@ -155,21 +398,43 @@ func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *h
 		} else if ranges != nil && len(ranges) == 1 {
 			headers.Set("Content-Length", strconv.FormatInt(int64(ranges[0].End-ranges[0].Start), 10))
 			headers.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", ranges[0].Start, ranges[0].End-1, obj.ContentLength()))
+			if rangeMD5 {
+				checksum, err := objectRangeMD5(obj, ranges[0].Start, ranges[0].End)
+				if err != nil {
+					hummingbird.GetLogger(request).LogError("Error checksumming range of %s: %v", obj.Repr(), err)
+					hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+					return
+				}
+				headers.Set("Content-MD5", checksum)
+			}
 			writer.WriteHeader(http.StatusPartialContent)
-			obj.CopyRange(writer, ranges[0].Start, ranges[0].End)
+			obj.CopyRange(body, ranges[0].Start, ranges[0].End)
 			return
 		} else if ranges != nil && len(ranges) > 1 {
-			w := hummingbird.NewMultiWriter(writer)
+			w := hummingbird.NewMultiWriter(body)
 			responseLength := int64(4 + len(w.Boundary()) + (len(w.Boundary())+len(metadata["Content-Type"])+47)*len(ranges))
-			for _, rng := range ranges {
+			checksums := make([]string, len(ranges))
+			for i, rng := range ranges {
 				responseLength += int64(len(fmt.Sprintf("%d-%d/%d", rng.Start, rng.End-1, obj.ContentLength()))) + rng.End - rng.Start
+				if rangeMD5 {
+					if checksums[i], err = objectRangeMD5(obj, rng.Start, rng.End); err != nil {
+						hummingbird.GetLogger(request).LogError("Error checksumming range of %s: %v", obj.Repr(), err)
+						hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+						return
+					}
+					responseLength += int64(len("Content-MD5: \r\n") + len(checksums[i]))
+				}
 			}
 			headers.Set("Content-Length", strconv.FormatInt(responseLength, 10))
 			headers.Set("Content-Type", "multipart/byteranges;boundary="+w.Boundary())
 			writer.WriteHeader(http.StatusPartialContent)
-			for _, rng := range ranges {
-				part, _ := w.CreatePart(textproto.MIMEHeader{"Content-Type": []string{metadata["Content-Type"]},
-					"Content-Range": []string{fmt.Sprintf("bytes %d-%d/%d", rng.Start, rng.End-1, obj.ContentLength())}})
+			for i, rng := range ranges {
+				partHeaders := textproto.MIMEHeader{"Content-Type": []string{metadata["Content-Type"]},
+					"Content-Range": []string{fmt.Sprintf("bytes %d-%d/%d", rng.Start, rng.End-1, obj.ContentLength())}}
+				if rangeMD5 {
+					partHeaders["Content-MD5"] = []string{checksums[i]}
+				}
+				part, _ := w.CreatePart(partHeaders)
 				obj.CopyRange(part, rng.Start, rng.End)
 			}
 			w.Close()
@@ -180,12 +445,12 @@ func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *h
 	if request.Method == "GET" {
 		if server.checkEtags {
 			hash := md5.New()
-			obj.Copy(writer, hash)
+			obj.Copy(body, hash)
 			if hex.EncodeToString(hash.Sum(nil)) != metadata["ETag"] {
 				obj.Quarantine()
 			}
 		} else {
-			obj.Copy(writer)
+			obj.Copy(body)
 		}
 	} else {
 		writer.Write([]byte{})
@@ -194,6 +459,10 @@ func (server *ObjectServer) ObjGetHandler(writer http.ResponseWriter, request *h
 
 func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := hummingbird.GetVars(request)
+	if server.inMaintenance() {
+		hummingbird.StandardResponse(writer, 503)
+		return
+	}
 	outHeaders := writer.Header()
 
 	requestTimestamp, err := hummingbird.StandardizeTimestamp(request.Header.Get("X-Timestamp"))
@@ -206,6 +475,13 @@ func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *h
 		http.Error(writer, fmt.Sprintf("Invalid path: %s", request.URL.Path), http.StatusBadRequest)
 		return
 	}
+	if rule, ok := server.namingRules[vars["account"]+"/"+vars["container"]]; ok {
+		if reason := rule.check(vars["obj"]); reason != "" {
+			hummingbird.GetLogger(request).LogInfo("Rejecting PUT %s: %s", request.URL.Path, reason)
+			http.Error(writer, reason, http.StatusBadRequest)
+			return
+		}
+	}
 	if request.Header.Get("Content-Type") == "" {
 		http.Error(writer, "No content type", http.StatusBadRequest)
 		return
@@ -216,6 +492,22 @@ func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *h
 			return
 		}
 	}
+	policy, _ := strconv.Atoi(request.Header.Get("X-Backend-Storage-Policy-Index"))
+	if server.placementCheck != "off" {
+		if reason := server.misplaced(policy, vars); reason != "" {
+			hummingbird.GetLogger(request).LogError("Misplaced object PUT %s: %s", request.URL.Path, reason)
+			if server.placementCheck == "reject" {
+				// 421 Misdirected Request; net/http only names it from Go 1.11.
+				http.Error(writer, "Object does not belong on this device", 421)
+				return
+			}
+		}
+	}
+	maxObjectSize := server.maxObjectSize[policy]
+	if maxObjectSize > 0 && request.ContentLength > maxObjectSize {
+		hummingbird.StandardResponse(writer, http.StatusRequestEntityTooLarge)
+		return
+	}
 
 	obj, err := server.newObject(request, vars, false)
 	if err != nil {
@@ -244,7 +536,45 @@ func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *h
 		}
 	}
 
-	tempFile, err := obj.SetData(request.ContentLength)
+	var body io.Reader = request.Body
+	if maxObjectSize > 0 {
+		// read at most one byte past the limit, so chunked uploads are cut off as soon as they're too large.
+		body = io.LimitReader(request.Body, maxObjectSize+1)
+	}
+	var slowUpload *slowUploadReader
+	if server.minUploadRate > 0 {
+		slowUpload = newSlowUploadReader(body, server.minUploadRate, server.minUploadGrace, hangUpSlowUpload(writer))
+		defer slowUpload.stop()
+		body = slowUpload
+	}
+	if rate := server.accountBandwidthLimit(vars["account"]); rate > 0 {
+		body = &shapedReader{Reader: body, bandwidth: server.bandwidth, key: vars["account"] + "/PUT", rate: rate}
+	}
+	contentLength := request.ContentLength
+	var staged *stagedUpload
+	if token := request.Header.Get("X-Upload-Token"); token != "" && server.resumableUploads {
+		upload, status, offset := server.stageUpload(request, vars, body, maxObjectSize)
+		if upload == nil {
+			if status == http.StatusRequestTimeout && slowUpload.stop() {
+				return
+			}
+			outHeaders.Set("X-Upload-Offset", strconv.FormatInt(offset, 10))
+			if status == http.StatusRequestTimeout {
+				outHeaders.Set("Connection", "close")
+			}
+			if status == 507 {
+				hummingbird.CustomErrorResponse(writer, 507, vars)
+			} else {
+				hummingbird.StandardResponse(writer, status)
+			}
+			return
+		}
+		defer upload.close()
+		staged = upload
+		body, contentLength = upload.file, upload.size
+	}
+
+	tempFile, err := obj.SetData(contentLength)
 	if err == DriveFullError {
 		hummingbird.GetLogger(request).LogDebug("Not enough space available")
 		hummingbird.CustomErrorResponse(writer, 507, vars)
@@ -256,15 +586,27 @@ func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *h
 	}
 
 	hash := md5.New()
-	totalSize, err := hummingbird.Copy(request.Body, tempFile, hash)
+	totalSize, err := hummingbird.Copy(body, tempFile, hash)
 	if err == io.ErrUnexpectedEOF {
 		hummingbird.StandardResponse(writer, 499)
 		return
+	} else if err == errSlowUpload {
+		hummingbird.GetLogger(request).LogError("Aborting PUT %s: %v", request.URL.Path, err)
+		if slowUpload.stop() {
+			return
+		}
+		writer.Header().Set("Connection", "close")
+		hummingbird.StandardResponse(writer, http.StatusRequestTimeout)
+		return
 	} else if err != nil {
 		hummingbird.GetLogger(request).LogError("Error writing to file: %s", err.Error())
 		hummingbird.StandardResponse(writer, http.StatusInternalServerError)
 		return
 	}
+	if maxObjectSize > 0 && totalSize > maxObjectSize {
+		hummingbird.StandardResponse(writer, http.StatusRequestEntityTooLarge)
+		return
+	}
 	metadata := map[string]string{
 		"name":           "/" + vars["account"] + "/" + vars["container"] + "/" + vars["obj"],
 		"X-Timestamp":    requestTimestamp,
@@ -291,12 +633,124 @@ func (server *ObjectServer) ObjPutHandler(writer http.ResponseWriter, request *h
 		hummingbird.StandardResponse(writer, http.StatusInternalServerError)
 		return
 	}
-	server.containerUpdates(request, metadata, request.Header.Get("X-Delete-At"), vars, hummingbird.GetLogger(request))
+	if staged != nil {
+		staged.remove()
+	}
+	server.containerUpdates(request, metadata, request.Header.Get("X-Delete-At"), "", vars, hummingbird.GetLogger(request))
 	hummingbird.StandardResponse(writer, http.StatusCreated)
 }
 
+// ObjPostHandler updates an object's metadata, either by writing a .meta file ("fast" post_mode) or by rewriting the whole object ("copy").
+func (server *ObjectServer) ObjPostHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := hummingbird.GetVars(request)
+	if server.inMaintenance() {
+		hummingbird.StandardResponse(writer, 503)
+		return
+	}
+	requestTimestamp, err := hummingbird.StandardizeTimestamp(request.Header.Get("X-Timestamp"))
+	if err != nil {
+		hummingbird.GetLogger(request).LogError("Error standardizing request X-Timestamp: %s", err.Error())
+		http.Error(writer, "Invalid X-Timestamp header", http.StatusBadRequest)
+		return
+	}
+	if deleteAt := request.Header.Get("X-Delete-At"); deleteAt != "" {
+		if deleteTime, err := hummingbird.ParseDate(deleteAt); err != nil || deleteTime.Before(time.Now()) {
+			http.Error(writer, "X-Delete-At in past", 400)
+			return
+		}
+	}
+
+	obj, err := server.newObject(request, vars, server.postMode == "copy")
+	if err != nil {
+		hummingbird.GetLogger(request).LogError("Error getting obj: %s", err.Error())
+		hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	defer obj.Close()
+	if !obj.Exists() {
+		hummingbird.StandardResponse(writer, http.StatusNotFound)
+		return
+	}
+	origMetadata := obj.Metadata()
+	if deleteAt, ok := origMetadata["X-Delete-At"]; ok {
+		if deleteTime, err := hummingbird.ParseDate(deleteAt); err == nil && deleteTime.Before(time.Now()) {
+			if backendRequest(request) {
+				writer.Header().Set("X-Backend-Not-Found-Reason", "expired")
+			}
+			hummingbird.StandardResponse(writer, http.StatusNotFound)
+			return
+		}
+	}
+	if origTimestamp, ok := origMetadata["X-Timestamp"]; ok && origTimestamp >= requestTimestamp {
+		writer.Header().Set("X-Backend-Timestamp", origTimestamp)
+		hummingbird.StandardResponse(writer, http.StatusConflict)
+		return
+	}
+
+	metadata := map[string]string{
+		"name":        origMetadata["name"],
+		"X-Timestamp": requestTimestamp,
+	}
+	for key := range request.Header {
+		if allowed, ok := server.allowedHeaders[key]; (ok && allowed) || strings.HasPrefix(key, "X-Object-Meta-") {
+			metadata[key] = request.Header.Get(key)
+		}
+	}
+
+	if server.postMode == "fast" {
+		err = obj.CommitMeta(metadata)
+	} else {
+		for key, value := range origMetadata {
+			if key == "Content-Length" || key == "Content-Type" || key == "ETag" || strings.HasPrefix(key, "X-Object-Sysmeta-") {
+				metadata[key] = value
+			}
+		}
+		// the new copy is written through a second instance, since SetData closes this one's data file.
+		var dst Object
+		var tempFile io.Writer
+		if dst, err = server.newObject(request, vars, false); err != nil {
+			hummingbird.GetLogger(request).LogError("Error getting obj: %s", err.Error())
+			hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+			return
+		}
+		defer dst.Close()
+		tempFile, err = dst.SetData(obj.ContentLength())
+		if err == DriveFullError {
+			hummingbird.GetLogger(request).LogDebug("Not enough space available")
+			hummingbird.CustomErrorResponse(writer, 507, vars)
+			return
+		} else if err != nil {
+			hummingbird.GetLogger(request).LogError("Error making new file: %s", err.Error())
+			hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+			return
+		}
+		if _, err := obj.Copy(tempFile); err != nil {
+			hummingbird.GetLogger(request).LogError("Error copying %s: %s", obj.Repr(), err.Error())
+			hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+			return
+		}
+		err = dst.Commit(metadata)
+	}
+	if err != nil {
+		hummingbird.GetLogger(request).LogError("Error saving object metadata: %v", err)
+		hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	// a fast POST leaves the listing as it was, but a copy is a new version of the object.
+	if server.postMode == "fast" {
+		server.deleteAtUpdates(request, metadata["X-Delete-At"], origMetadata["X-Delete-At"], vars, hummingbird.GetLogger(request))
+	} else {
+		server.containerUpdates(request, metadata, metadata["X-Delete-At"], origMetadata["X-Delete-At"], vars, hummingbird.GetLogger(request))
+	}
+	hummingbird.StandardResponse(writer, http.StatusAccepted)
+}
+
 func (server *ObjectServer) ObjDeleteHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := hummingbird.GetVars(request)
+	if server.inMaintenance() {
+		hummingbird.StandardResponse(writer, 503)
+		return
+	}
 	headers := writer.Header()
 	requestTimestamp, err := hummingbird.StandardizeTimestamp(request.Header.Get("X-Timestamp"))
 	if err != nil {
@@ -366,11 +820,28 @@ func (server *ObjectServer) ObjDeleteHandler(writer http.ResponseWriter, request
 		return
 	}
 	headers.Set("X-Backend-Timestamp", metadata["X-Timestamp"])
-	server.containerUpdates(request, metadata, deleteAt, vars, hummingbird.GetLogger(request))
+	server.containerUpdates(request, metadata, deleteAt, "", vars, hummingbird.GetLogger(request))
 	hummingbird.StandardResponse(writer, responseStatus)
 }
 
+func (server *ObjectServer) inMaintenance() bool {
+	return atomic.LoadInt32(&server.maintenance) == 1
+}
+
+// MaintenanceHandler turns maintenance mode on (PUT) or off (DELETE).  While it's on, writes are rejected but reads are still served.
+func (server *ObjectServer) MaintenanceHandler(writer http.ResponseWriter, request *http.Request) {
+	if request.Method == "PUT" {
+		atomic.StoreInt32(&server.maintenance, 1)
+	} else {
+		atomic.StoreInt32(&server.maintenance, 0)
+	}
+	hummingbird.StandardResponse(writer, 204)
+}
+
 func (server *ObjectServer) HealthcheckHandler(writer http.ResponseWriter, request *http.Request) {
+	if server.inMaintenance() {
+		writer.Header().Set("X-Maintenance-Mode", "true")
+	}
 	writer.Header().Set("Content-Length", "2")
 	writer.WriteHeader(http.StatusOK)
 	writer.Write([]byte("OK"))
@@ -382,6 +853,67 @@ func (server *ObjectServer) ReconHandler(writer http.ResponseWriter, request *ht
 	return
 }
 
+// isMount is hummingbird.IsMount, overridable for tests.
+var isMount = hummingbird.IsMount
+
+type readiness struct {
+	Ready    bool     `json:"ready"`
+	Problems []string `json:"problems,omitempty"`
+}
+
+// checkReadiness loads any rings that haven't been loaded yet and checks that each policy's ring has a mounted
+// local device.
+func (server *ObjectServer) checkReadiness() readiness {
+	server.readyLock.Lock()
+	defer server.readyLock.Unlock()
+	var problems []string
+	for policy := range server.objEngines {
+		ring, ok := server.readyRings[policy]
+		if !ok {
+			var err error
+			if ring, err = getRing("object", server.hashPathPrefix, server.hashPathSuffix, policy); err != nil {
+				problems = append(problems, fmt.Sprintf("Ring for policy %d not loaded: %v", policy, err))
+				continue
+			}
+			server.readyRings[policy] = ring
+		}
+		devs, err := ring.LocalDevices(server.bindPort)
+		if err != nil || len(devs) == 0 {
+			problems = append(problems, fmt.Sprintf("No local devices in ring for policy %d", policy))
+			continue
+		}
+		mounted := !server.checkMounts
+		for _, dev := range devs {
+			if m, err := isMount(filepath.Join(server.driveRoot, dev.Device)); err == nil && m {
+				mounted = true
+				break
+			}
+		}
+		if !mounted {
+			problems = append(problems, fmt.Sprintf("No mounted devices for policy %d", policy))
+		}
+	}
+	return readiness{Ready: len(problems) == 0, Problems: problems}
+}
+
+// ReadyHandler returns 200 once the server can serve objects, or 503 with the reasons it can't.  Unlike /healthcheck,
+// which only says the process is up, this checks rings and mounts.
+func (server *ObjectServer) ReadyHandler(writer http.ResponseWriter, request *http.Request) {
+	status := server.checkReadiness()
+	data, err := json.Marshal(status)
+	if err != nil {
+		hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	if status.Ready {
+		writer.WriteHeader(http.StatusOK)
+	} else {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writer.Write(data)
+}
+
 func (server *ObjectServer) DiskUsageHandler(writer http.ResponseWriter, request *http.Request) {
 	data, err := server.diskInUse.MarshalJSON()
 	if err == nil {
@@ -394,6 +926,118 @@ func (server *ObjectServer) DiskUsageHandler(writer http.ResponseWriter, request
 	return
 }
 
+// ManifestHandler returns per-partition object and tombstone counts for a device, so tooling can size up a device without walking it.
+func (server *ObjectServer) ManifestHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := hummingbird.GetVars(request)
+	policy, err := strconv.Atoi(request.Header.Get("X-Backend-Storage-Policy-Index"))
+	if err != nil {
+		policy = 0
+	}
+	manifest, err := server.manifests.get(filepath.Join(server.driveRoot, vars["device"], PolicyDir(policy)))
+	if err != nil {
+		hummingbird.GetLogger(request).LogError("Error scanning partitions on %s: %v", vars["device"], err)
+		hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
+
+// DataMetadataHandler returns the metadata stored with one of a hash directory's .data files, so the auditor on a peer can repair damaged xattrs.
+func (server *ObjectServer) DataMetadataHandler(writer http.ResponseWriter, request *http.Request) {
+	vars := hummingbird.GetVars(request)
+	timestamp := request.FormValue("timestamp")
+	if _, err := hex.DecodeString(vars["hash"]); err != nil || len(vars["hash"]) != 32 || timestamp == "" || strings.Contains(timestamp, "/") {
+		hummingbird.StandardResponse(writer, http.StatusBadRequest)
+		return
+	}
+	policy, err := strconv.Atoi(request.Header.Get("X-Backend-Storage-Policy-Index"))
+	if err != nil {
+		policy = 0
+	}
+	dataFile := filepath.Join(server.driveRoot, vars["device"], PolicyDir(policy), vars["partition"], vars["hash"][29:32], vars["hash"], timestamp+".data")
+	metadata, err := ReadMetadata(dataFile)
+	if err != nil {
+		hummingbird.StandardResponse(writer, http.StatusNotFound)
+		return
+	}
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		hummingbird.StandardResponse(writer, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
+
+func (server *ObjectServer) LatencyHandler(writer http.ResponseWriter, request *http.Request) {
+	data, err := server.deviceLatency.MarshalJSON()
+	if err == nil {
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(data)
+	} else {
+		writer.WriteHeader(http.StatusInternalServerError)
+		writer.Write([]byte(err.Error()))
+	}
+	return
+}
+
+// jsonError is the body sent with error responses when json_error_bodies is enabled.
+type jsonError struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id"`
+}
+
+// jsonErrorWriter replaces the body of any 4xx or 5xx response with a jsonError, unless the handler is already
+// sending a JSON body of its own, like /readycheck's list of problems.
+type jsonErrorWriter struct {
+	http.ResponseWriter
+	requestID string
+	failed    bool
+}
+
+func (w *jsonErrorWriter) WriteHeader(status int) {
+	if status < 400 || w.Header().Get("Content-Type") == "application/json" {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.failed = true
+	body, _ := json.Marshal(jsonError{Code: status, Message: http.StatusText(status), RequestID: w.requestID})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(status)
+	w.ResponseWriter.Write(body)
+}
+
+func (w *jsonErrorWriter) Write(b []byte) (int, error) {
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *jsonErrorWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return w.ResponseWriter.(http.Hijacker).Hijack()
+}
+
+// JSONErrorBodies sends machine-readable error bodies in place of the usual HTML ones if json_error_bodies is set.
+func (server *ObjectServer) JSONErrorBodies(next http.Handler) http.Handler {
+	if !server.jsonErrorBodies {
+		return next
+	}
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		next.ServeHTTP(&jsonErrorWriter{ResponseWriter: writer, requestID: request.Header.Get("X-Trans-Id")}, request)
+	})
+}
+
 func (server *ObjectServer) LogRequest(next http.Handler) http.Handler {
 	fn := func(writer http.ResponseWriter, request *http.Request) {
 		newWriter := &hummingbird.WebWriter{ResponseWriter: writer, Status: 500, ResponseStarted: false}
@@ -402,6 +1046,12 @@ func (server *ObjectServer) LogRequest(next http.Handler) http.Handler {
 		start := time.Now()
 		request = hummingbird.SetLogger(request, requestLogger)
 		next.ServeHTTP(newWriter, request)
+		if device := hummingbird.GetVars(request)["device"]; device != "" {
+			switch request.Method {
+			case "GET", "PUT", "HEAD":
+				server.deviceLatency.Observe(device, request.Method, time.Since(start))
+			}
+		}
 		forceAcquire := request.Header.Get("X-Force-Acquire") == "true"
 
 		extraInfo := "-"
@@ -463,7 +1113,11 @@ func (server *ObjectServer) AcquireDevice(next http.Handler) http.Handler {
 func (server *ObjectServer) updateDeviceLocks(seconds int64) {
 	reloadTime := time.Duration(seconds) * time.Second
 	for {
-		time.Sleep(reloadTime)
+		select {
+		case <-server.stop:
+			return
+		case <-time.After(reloadTime):
+		}
 		for _, key := range server.diskInUse.Keys() {
 			lockPath := filepath.Join(server.driveRoot, key, "lock_device")
 			if hummingbird.Exists(lockPath) {
@@ -475,17 +1129,29 @@ func (server *ObjectServer) updateDeviceLocks(seconds int64) {
 	}
 }
 
+// Stop cancels the server's background loops.
+func (server *ObjectServer) Stop() {
+	server.stopOnce.Do(func() { close(server.stop) })
+}
+
 func (server *ObjectServer) GetHandler(config hummingbird.Config) http.Handler {
-	commonHandlers := alice.New(server.LogRequest, middleware.ValidateRequest, server.AcquireDevice)
+	commonHandlers := alice.New(server.LogRequest, server.JSONErrorBodies, middleware.ValidateRequest, server.AcquireDevice)
 	router := hummingbird.NewRouter()
 	router.Get("/healthcheck", commonHandlers.ThenFunc(server.HealthcheckHandler))
+	router.Get("/readycheck", commonHandlers.ThenFunc(server.ReadyHandler))
 	router.Get("/diskusage", commonHandlers.ThenFunc(server.DiskUsageHandler))
+	router.Get("/latency", commonHandlers.ThenFunc(server.LatencyHandler))
+	router.Get("/manifest/:device", commonHandlers.ThenFunc(server.ManifestHandler))
+	router.Get("/metadata/:device/:partition/:hash", commonHandlers.ThenFunc(server.DataMetadataHandler))
+	router.Put("/maintenance", commonHandlers.ThenFunc(server.MaintenanceHandler))
+	router.Delete("/maintenance", commonHandlers.ThenFunc(server.MaintenanceHandler))
 	router.Get("/recon/:method/:recon_type", commonHandlers.ThenFunc(server.ReconHandler))
 	router.Get("/recon/:method", commonHandlers.ThenFunc(server.ReconHandler))
 	router.Get("/:device/:partition/:account/:container/*obj", commonHandlers.ThenFunc(server.ObjGetHandler))
 	router.Head("/:device/:partition/:account/:container/*obj", commonHandlers.ThenFunc(server.ObjGetHandler))
 	router.Put("/:device/:partition/:account/:container/*obj", commonHandlers.ThenFunc(server.ObjPutHandler))
 	router.Delete("/:device/:partition/:account/:container/*obj", commonHandlers.ThenFunc(server.ObjDeleteHandler))
+	router.Post("/:device/:partition/:account/:container/*obj", commonHandlers.ThenFunc(server.ObjPostHandler))
 	router.Get("/debug/pprof/:parm", http.DefaultServeMux)
 	router.Post("/debug/pprof/:parm", http.DefaultServeMux)
 	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -502,13 +1168,22 @@ func GetServer(serverconf hummingbird.Config, flags *flag.FlagSet) (bindIP strin
 			"X-Object-Manifest":     true,
 			"X-Static-Large-Object": true,
 		},
+		stop: make(chan struct{}),
 	}
 	server.hashPathPrefix, server.hashPathSuffix, err = hummingbird.GetHashPrefixAndSuffix()
 	if err != nil {
 		return "", 0, nil, nil, err
 	}
 	server.objEngines = make(map[int]ObjectEngine)
+	server.maxObjectSize = make(map[int]int64)
+	defaultMaxObjectSize := serverconf.GetInt("app:object-server", "max_object_size", 0)
 	for _, policy := range hummingbird.LoadPolicies() {
+		server.maxObjectSize[policy.Index] = defaultMaxObjectSize
+		if maxObjectSize, ok := policy.Config["max_object_size"]; ok {
+			if server.maxObjectSize[policy.Index], err = strconv.ParseInt(maxObjectSize, 10, 64); err != nil {
+				return "", 0, nil, nil, fmt.Errorf("Invalid max_object_size for policy %d: %v", policy.Index, err)
+			}
+		}
 		if newEngine, err := FindEngine(policy.Type); err != nil {
 			return "", 0, nil, nil, fmt.Errorf("Unable to find object engine type %s: %v", policy.Type, err)
 		} else {
@@ -525,9 +1200,64 @@ func GetServer(serverconf hummingbird.Config, flags *flag.FlagSet) (bindIP strin
 	server.logLevel = serverconf.GetDefault("app:object-server", "log_level", "INFO")
 	server.diskInUse = hummingbird.NewKeyedLimit(serverconf.GetLimit("app:object-server", "disk_limit", 25, 0))
 	server.accountDiskInUse = hummingbird.NewKeyedLimit(serverconf.GetLimit("app:object-server", "account_rate_limit", 20, 0))
+	if serverconf.GetBool("app:object-server", "maintenance_mode", false) {
+		server.maintenance = 1
+	}
+	server.deviceLatency = hummingbird.NewLatencyTracker()
+	server.manifests = &manifestCache{
+		ttl:       time.Duration(serverconf.GetFloat("app:object-server", "manifest_cache_seconds", 60) * float64(time.Second)),
+		manifests: make(map[string]*cachedManifest),
+	}
+	server.postMode = serverconf.GetDefault("app:object-server", "post_mode", "fast")
+	if server.postMode != "fast" && server.postMode != "copy" {
+		return "", 0, nil, nil, fmt.Errorf("Invalid post_mode %q, must be fast or copy", server.postMode)
+	}
+	server.minUploadRate = serverconf.GetFloat("app:object-server", "min_upload_rate", 0)
+	server.minUploadGrace = time.Duration(serverconf.GetFloat("app:object-server", "min_upload_rate_grace", 10) * float64(time.Second))
+	server.resumableUploads = serverconf.GetBool("app:object-server", "resumable_uploads", false)
+	server.uploadTimeout = time.Duration(serverconf.GetFloat("app:object-server", "resumable_upload_timeout", 86400) * float64(time.Second))
+	server.reserve = serverconf.GetInt("app:object-server", "fallocate_reserve", 0)
+	server.inodeReserve = serverconf.GetInt("app:object-server", "inode_reserve", 0)
+	if server.namingRules, err = loadNamingRules(serverconf); err != nil {
+		return "", 0, nil, nil, err
+	}
+	server.bandwidthLimit = serverconf.GetInt("app:object-server", "account_bandwidth_limit", 0)
+	server.accountBandwidth = make(map[string]int64)
+	for account, limit := range serverconf.File["account-bandwidth-limits"] {
+		if server.accountBandwidth[account], err = strconv.ParseInt(limit, 10, 64); err != nil {
+			return "", 0, nil, nil, fmt.Errorf("Invalid bandwidth limit for account %s: %q", account, limit)
+		}
+	}
+	server.bandwidth = hummingbird.NewKeyedBandwidth()
+	server.jsonErrorBodies = serverconf.GetBool("app:object-server", "json_error_bodies", false)
 	server.expiringDivisor = serverconf.GetInt("app:object-server", "expiring_objects_container_divisor", 86400)
 	bindIP = serverconf.GetDefault("app:object-server", "bind_ip", "0.0.0.0")
 	bindPort = int(serverconf.GetInt("app:object-server", "bind_port", 6000))
+	server.bindPort = bindPort
+	server.objRings = make(map[int]hummingbird.Ring)
+	server.readyRings = make(map[int]hummingbird.Ring)
+	server.placementCheck = serverconf.GetDefault("app:object-server", "placement_check", "off")
+	// writes are only expected on primaries, unless handoffs are meant to take them while primaries are down.
+	server.allowedHandoffs = int(serverconf.GetInt("app:object-server", "placement_check_handoffs", 0))
+	switch server.placementCheck {
+	case "off":
+	case "log", "reject":
+		localAddrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return "", 0, nil, nil, fmt.Errorf("Unable to get local addresses: %v", err)
+		}
+		server.localIPs = make(map[string]bool)
+		for _, addr := range localAddrs {
+			server.localIPs[strings.Split(addr.String(), "/")[0]] = true
+		}
+		for _, policy := range hummingbird.LoadPolicies() {
+			if server.objRings[policy.Index], err = hummingbird.GetRing("object", server.hashPathPrefix, server.hashPathSuffix, policy.Index); err != nil {
+				return "", 0, nil, nil, fmt.Errorf("Unable to load ring for placement_check: %v", err)
+			}
+		}
+	default:
+		return "", 0, nil, nil, fmt.Errorf("Invalid placement_check %q, must be off, log or reject", server.placementCheck)
+	}
 	if allowedHeaders, ok := serverconf.Get("app:object-server", "allowed_headers"); ok {
 		headers := strings.Split(allowedHeaders, ",")
 		for i := range headers {
@@ -537,6 +1267,9 @@ func GetServer(serverconf hummingbird.Config, flags *flag.FlagSet) (bindIP strin
 	if server.logger, err = hummingbird.SetupLogger(serverconf, flags, "app:object-server", "object-server"); err != nil {
 		return "", 0, nil, nil, fmt.Errorf("Error setting up logger: %v", err)
 	}
+	setQuarantineLimit(serverconf.GetInt("app:object-server", "quarantine_limit", 0),
+		time.Duration(serverconf.GetFloat("app:object-server", "quarantine_limit_interval", 3600)*float64(time.Second)),
+		server.logger, quarantineStats(serverconf, "app:object-server", "objectserver"))
 
 	server.updateTimeout = time.Duration(serverconf.GetFloat("app:object-server", "container_update_timeout", 0.25) * float64(time.Second))
 	connTimeout := time.Duration(serverconf.GetFloat("app:object-server", "conn_timeout", 1.0) * float64(time.Second))
@@ -550,6 +1283,9 @@ func GetServer(serverconf hummingbird.Config, flags *flag.FlagSet) (bindIP strin
 	if deviceLockUpdateSeconds > 0 {
 		go server.updateDeviceLocks(deviceLockUpdateSeconds)
 	}
+	if server.resumableUploads {
+		go server.cleanupUploads()
+	}
 
 	statsdHost := serverconf.GetDefault("app:object-server", "log_statsd_host", "")
 	if statsdHost != "" {
@@ -16,19 +16,28 @@
 package objectserver
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -228,6 +237,64 @@ func TestGetRanges(t *testing.T) {
 	assert.Equal(t, 2, strings.Count(string(body), "UVWXYZ"))
 }
 
+func TestGetRangeChecksum(t *testing.T) {
+	ts, err := makeObjectServer()
+	require.Nil(t, err)
+	defer ts.Close()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port),
+		bytes.NewBuffer([]byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ")))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", "26")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+
+	rangeMD5 := func(data string) string {
+		sum := md5.Sum([]byte(data))
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+	getRanges := func(ranges string, checksum bool) (*http.Response, []byte) {
+		req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), nil)
+		require.Nil(t, err)
+		req.Header.Set("Range", ranges)
+		if checksum {
+			req.Header.Set("X-Range-Checksum", "md5")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		return resp, body
+	}
+
+	resp, body := getRanges("bytes=2-5", false)
+	require.Equal(t, "CDEF", string(body))
+	require.Equal(t, "", resp.Header.Get("Content-MD5"))
+
+	resp, body = getRanges("bytes=2-5", true)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "CDEF", string(body))
+	require.Equal(t, rangeMD5("CDEF"), resp.Header.Get("Content-MD5"))
+
+	resp, body = getRanges("bytes=0-1,-3", true)
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	require.Nil(t, err)
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	for _, expected := range []string{"AB", "XYZ"} {
+		part, err := mr.NextPart()
+		require.Nil(t, err)
+		data, err := ioutil.ReadAll(part)
+		require.Nil(t, err)
+		require.Equal(t, expected, string(data))
+		require.Equal(t, rangeMD5(expected), part.Header.Get("Content-MD5"))
+	}
+}
+
 func TestBadEtag(t *testing.T) {
 	ts, err := makeObjectServer()
 	assert.Nil(t, err)
@@ -572,3 +639,961 @@ func TestAccountAcquireDevice(t *testing.T) {
 	//1 exiting goroutine
 	<-done1
 }
+
+func TestDeviceLatency(t *testing.T) {
+	ts, err := makeObjectServer()
+	require.Nil(t, err)
+	defer ts.Close()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME DATA")))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", "9")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+	resp, err = ts.Do("GET", "/sda/0/a/c/o", nil)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	resp, err = ts.Do("DELETE", "/sda/0/a/c/o", nil)
+	require.Nil(t, err)
+
+	resp, err = ts.Do("GET", "/latency", nil)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	var report map[string]map[string]map[string]float64
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, float64(1), report["sda"]["PUT"]["count"])
+	assert.Equal(t, float64(1), report["sda"]["GET"]["count"])
+	assert.True(t, report["sda"]["GET"]["p99"] > 0)
+	_, ok := report["sda"]["DELETE"]
+	assert.False(t, ok)
+}
+
+func TestMaintenanceMode(t *testing.T) {
+	ts, err := makeObjectServer()
+	require.Nil(t, err)
+	defer ts.Close()
+
+	put := func() int {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME DATA")))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Length", "9")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return resp.StatusCode
+	}
+	require.Equal(t, 201, put())
+
+	resp, err := ts.Do("PUT", "/maintenance", nil)
+	require.Nil(t, err)
+	require.Equal(t, 204, resp.StatusCode)
+
+	assert.Equal(t, 503, put())
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	assert.Equal(t, 503, resp.StatusCode)
+	resp, err = ts.Do("GET", "/sda/0/a/c/o", nil)
+	require.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	resp, err = ts.Do("HEAD", "/sda/0/a/c/o", nil)
+	require.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	resp, err = ts.Do("GET", "/healthcheck", nil)
+	require.Nil(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, "true", resp.Header.Get("X-Maintenance-Mode"))
+
+	resp, err = ts.Do("DELETE", "/maintenance", nil)
+	require.Nil(t, err)
+	require.Equal(t, 204, resp.StatusCode)
+	assert.Equal(t, 201, put())
+	resp, err = ts.Do("GET", "/healthcheck", nil)
+	require.Nil(t, err)
+	assert.Equal(t, "", resp.Header.Get("X-Maintenance-Mode"))
+}
+
+func TestPutMaxObjectSize(t *testing.T) {
+	ts, err := makeObjectServer("max_object_size", "10")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME MORE DATA")))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", "14")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	assert.Equal(t, 413, resp.StatusCode)
+
+	// ioutil.NopCloser hides the length, so the request is sent chunked.
+	req, err = http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), ioutil.NopCloser(bytes.NewBuffer([]byte("SOME MORE DATA"))))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	assert.Equal(t, 413, resp.StatusCode)
+
+	resp, err = ts.Do("GET", "/sda/0/a/c/o", nil)
+	require.Nil(t, err)
+	assert.Equal(t, 404, resp.StatusCode)
+
+	req, err = http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), ioutil.NopCloser(bytes.NewBuffer([]byte("SOME DATA"))))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	assert.Equal(t, 201, resp.StatusCode)
+}
+
+func TestPutMaxObjectSizePerPolicy(t *testing.T) {
+	oldLoadPolicies := hummingbird.LoadPolicies
+	defer func() { hummingbird.LoadPolicies = oldLoadPolicies }()
+	hummingbird.LoadPolicies = func() hummingbird.PolicyList {
+		return hummingbird.PolicyList{
+			0: &hummingbird.Policy{Index: 0, Type: "replication", Name: "gold", Default: true},
+			1: &hummingbird.Policy{Index: 1, Type: "replication", Name: "small", Config: map[string]string{"max_object_size": "5"}},
+		}
+	}
+	ts, err := makeObjectServer("max_object_size", "10")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	put := func(policy string) int {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME DATA")))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Length", "9")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		req.Header.Set("X-Backend-Storage-Policy-Index", policy)
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, 201, put("0"))
+	assert.Equal(t, 413, put("1"))
+}
+
+type placementRing struct {
+	hummingbird.Ring
+	partition uint64
+	primaries []*hummingbird.Device
+	handoffs  []*hummingbird.Device
+}
+
+func (r *placementRing) GetPartition(account string, container string, object string) uint64 {
+	return r.partition
+}
+
+func (r *placementRing) GetNodes(partition uint64) []*hummingbird.Device {
+	return r.primaries
+}
+
+type placementHandoffs []*hummingbird.Device
+
+func (h *placementHandoffs) Next() *hummingbird.Device {
+	if len(*h) == 0 {
+		return nil
+	}
+	dev := (*h)[0]
+	*h = (*h)[1:]
+	return dev
+}
+
+func (r *placementRing) GetMoreNodes(partition uint64) hummingbird.MoreNodes {
+	handoffs := placementHandoffs(r.handoffs)
+	return &handoffs
+}
+
+func TestPutPlacementCheck(t *testing.T) {
+	ts, err := makeObjectServer("placement_check", "reject")
+	require.Nil(t, err)
+	defer ts.Close()
+	ts.objServer.bindPort = 6010
+	ts.objServer.objRings[0] = &placementRing{partition: 3,
+		primaries: []*hummingbird.Device{
+			{Id: 0, Device: "sda", Ip: "127.0.0.1", Port: 6010},
+			{Id: 1, Device: "sdb", Ip: "127.0.0.1", Port: 6020},
+			{Id: 2, Device: "sdc", Ip: "192.0.2.1", Port: 6010},
+		},
+		handoffs: []*hummingbird.Device{
+			{Id: 3, Device: "sdf", Ip: "192.0.2.1", Port: 6010},
+			{Id: 4, Device: "sde", Ip: "127.0.0.1", Port: 6010},
+		},
+	}
+
+	put := func(path string) int {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d%s", ts.host, ts.port, path), bytes.NewBuffer([]byte("SOME DATA")))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Length", "9")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return resp.StatusCode
+	}
+	assert.Equal(t, 201, put("/sda/3/a/c/o"))
+	assert.Equal(t, 421, put("/sda/4/a/c/o"))
+	assert.Equal(t, 421, put("/sdb/3/a/c/o"))
+	assert.Equal(t, 421, put("/sdc/3/a/c/o"))
+	assert.Equal(t, 421, put("/sdd/3/a/c/o"))
+	// sde is a local device in the ring, but only a handoff for the partition.
+	assert.Equal(t, 421, put("/sde/3/a/c/o"))
+
+	ts.objServer.allowedHandoffs = 1
+	assert.Equal(t, 421, put("/sde/3/a/c/o"))
+	ts.objServer.allowedHandoffs = 2
+	assert.Equal(t, 201, put("/sde/3/a/c/o"))
+
+	ts.objServer.placementCheck = "log"
+	assert.Equal(t, 201, put("/sdb/3/a/c/o"))
+}
+
+func TestStopCancelsDeviceLockUpdates(t *testing.T) {
+	ts, err := makeObjectServer()
+	require.Nil(t, err)
+	defer ts.Close()
+	done := make(chan bool)
+	go func() {
+		ts.objServer.updateDeviceLocks(3600)
+		done <- true
+	}()
+	ts.objServer.Stop()
+	ts.objServer.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("updateDeviceLocks didn't return after Stop")
+	}
+}
+
+func TestPutExpectContinue(t *testing.T) {
+	ts, err := makeObjectServer("max_object_size", "10")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	put := func(contentLength int) (*net.TCPConn, *bufio.Reader) {
+		conn, err := net.Dial("tcp", net.JoinHostPort(ts.host, strconv.Itoa(ts.port)))
+		require.Nil(t, err)
+		fmt.Fprintf(conn, "PUT /sda/0/a/c/o HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: %d\r\n"+
+			"X-Timestamp: %s\r\nX-Backend-Storage-Policy-Index: 0\r\nExpect: 100-continue\r\n\r\n", contentLength, hummingbird.GetTimestamp())
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn.(*net.TCPConn), bufio.NewReader(conn)
+	}
+
+	// rejected before the body is sent
+	conn, reader := put(14)
+	resp, err := http.ReadResponse(reader, nil)
+	require.Nil(t, err)
+	require.Equal(t, 413, resp.StatusCode)
+	conn.Close()
+
+	// accepted, so the server asks for the body
+	conn, reader = put(4)
+	defer conn.Close()
+	resp, err = http.ReadResponse(reader, nil)
+	require.Nil(t, err)
+	require.Equal(t, 100, resp.StatusCode)
+	conn.Write([]byte("DATA"))
+	resp, err = http.ReadResponse(reader, nil)
+	require.Nil(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+}
+
+func testPostMode(t *testing.T, mode string) (dataTimestamp string, postTimestamp string, files []string) {
+	ts, err := makeObjectServer("post_mode", mode)
+	require.Nil(t, err)
+	defer ts.Close()
+
+	dataTimestamp = hummingbird.CanonicalTimestamp(float64(time.Now().Add(-time.Minute).UnixNano()) / 1e9)
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME DATA")))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Length", "9")
+	req.Header.Set("X-Timestamp", dataTimestamp)
+	req.Header.Set("X-Object-Meta-Color", "red")
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+
+	post := func(path, timestamp string) int {
+		req, err := http.NewRequest("POST", fmt.Sprintf("http://%s:%d%s", ts.host, ts.port, path), nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Timestamp", timestamp)
+		req.Header.Set("X-Object-Meta-Shape", "round")
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return resp.StatusCode
+	}
+	postTimestamp = hummingbird.GetTimestamp()
+	require.Equal(t, 202, post("/sda/0/a/c/o", postTimestamp))
+	require.Equal(t, 409, post("/sda/0/a/c/o", dataTimestamp))
+	require.Equal(t, 404, post("/sda/0/a/c/missing", postTimestamp))
+
+	resp, err = ts.Do("GET", "/sda/0/a/c/o", nil)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "SOME DATA", string(body))
+	require.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	require.Equal(t, "round", resp.Header.Get("X-Object-Meta-Shape"))
+	require.Equal(t, "", resp.Header.Get("X-Object-Meta-Color"))
+	require.Equal(t, postTimestamp, resp.Header.Get("X-Backend-Timestamp"))
+
+	hashDir := ObjHashDir(map[string]string{"device": "sda", "partition": "0", "account": "a", "container": "c", "obj": "o"},
+		ts.root, ts.objServer.hashPathPrefix, ts.objServer.hashPathSuffix, 0)
+	files, err = hummingbird.ReadDirNames(hashDir)
+	require.Nil(t, err)
+	return dataTimestamp, postTimestamp, files
+}
+
+func TestPostFastMode(t *testing.T) {
+	dataTimestamp, postTimestamp, files := testPostMode(t, "fast")
+	require.Equal(t, []string{dataTimestamp + ".data", postTimestamp + ".meta"}, files)
+}
+
+// asyncOps lists the "<op> <account>/<obj>" of each async pending update saved on the device.
+func asyncOps(t *testing.T, root, device string) []string {
+	ops := []string{}
+	files, _ := filepath.Glob(filepath.Join(root, device, "async_pending", "*", "*"))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		require.Nil(t, err)
+		v, err := hummingbird.PickleLoads(data)
+		require.Nil(t, err)
+		update := v.(map[interface{}]interface{})
+		ops = append(ops, fmt.Sprintf("%s %s/%s", update["op"], update["account"], update["obj"]))
+	}
+	return ops
+}
+
+func testPostDeleteAt(t *testing.T, mode string) {
+	ts, err := makeObjectServer("post_mode", mode)
+	require.Nil(t, err)
+	defer ts.Close()
+	var lock sync.Mutex
+	listings := []string{}
+	cs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		listings = append(listings, r.Method+" "+r.URL.Path+" "+r.Header.Get("X-Size"))
+		lock.Unlock()
+	}))
+	defer cs.Close()
+	u, err := url.Parse(cs.URL)
+	require.Nil(t, err)
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME DATA")))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+
+	post := func(deleteAt string) {
+		req, err := http.NewRequest("POST", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		req.Header.Set("X-Container-Partition", "1")
+		req.Header.Set("X-Container-Host", u.Host)
+		req.Header.Set("X-Container-Device", "sdb")
+		if deleteAt != "" {
+			req.Header.Set("X-Delete-At", deleteAt)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		require.Equal(t, 202, resp.StatusCode)
+	}
+	waitForOps := func(count int) []string {
+		for i := 0; i < 100 && len(asyncOps(t, ts.root, "sda")) < count; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return asyncOps(t, ts.root, "sda")
+	}
+	first := strconv.FormatInt(time.Now().Unix()+3600, 10)
+	second := strconv.FormatInt(time.Now().Unix()+7200, 10)
+	post(first)
+	require.Equal(t, []string{"PUT .expiring_objects/" + first + "-a/c/o"}, waitForOps(1))
+	post(second)
+	ops := waitForOps(3)
+	sort.Strings(ops)
+	require.Equal(t, []string{
+		"DELETE .expiring_objects/" + first + "-a/c/o",
+		"PUT .expiring_objects/" + first + "-a/c/o",
+		"PUT .expiring_objects/" + second + "-a/c/o",
+	}, ops)
+	post("")
+	require.Contains(t, waitForOps(4), "DELETE .expiring_objects/"+second+"-a/c/o")
+
+	lock.Lock()
+	defer lock.Unlock()
+	if mode == "fast" {
+		require.Equal(t, 0, len(listings))
+	} else {
+		require.Equal(t, []string{"PUT /sdb/1/a/c/o 9", "PUT /sdb/1/a/c/o 9", "PUT /sdb/1/a/c/o 9"}, listings)
+	}
+}
+
+func TestPostFastModeDeleteAt(t *testing.T) {
+	testPostDeleteAt(t, "fast")
+}
+
+func TestPostCopyModeDeleteAt(t *testing.T) {
+	testPostDeleteAt(t, "copy")
+}
+
+func TestPostExpired(t *testing.T) {
+	for _, mode := range []string{"fast", "copy"} {
+		ts, err := makeObjectServer("post_mode", mode)
+		require.Nil(t, err)
+		defer ts.Close()
+		deleteAt := time.Now().Unix() + 1
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME DATA")))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		req.Header.Set("X-Delete-At", strconv.FormatInt(deleteAt, 10))
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		require.Equal(t, 201, resp.StatusCode)
+		time.Sleep(time.Until(time.Unix(deleteAt+1, 0)))
+
+		req, err = http.NewRequest("POST", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), nil)
+		require.Nil(t, err)
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		req.Header.Set("X-Object-Meta-Foo", "bar")
+		resp, err = http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		require.Equal(t, 404, resp.StatusCode)
+
+		obj, err := ts.objServer.newObject(&http.Request{Header: http.Header{}}, map[string]string{"device": "sda", "partition": "0", "account": "a", "container": "c", "obj": "o"}, false)
+		require.Nil(t, err)
+		require.Equal(t, "", obj.Metadata()["X-Object-Meta-Foo"])
+		obj.Close()
+	}
+}
+
+func TestPostCopyMode(t *testing.T) {
+	_, postTimestamp, files := testPostMode(t, "copy")
+	require.Contains(t, files, postTimestamp+".data")
+	for _, file := range files {
+		require.False(t, strings.HasSuffix(file, ".meta"))
+	}
+}
+
+func TestManifest(t *testing.T) {
+	ts, err := makeObjectServer("manifest_cache_seconds", "0")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	send := func(method, path string) int {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://%s:%d%s", ts.host, ts.port, path), bytes.NewBuffer([]byte("SOME DATA")))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		if method == "PUT" {
+			req.Header.Set("Content-Length", "9")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return resp.StatusCode
+	}
+	require.Equal(t, 201, send("PUT", "/sda/1/a/c/o1"))
+	require.Equal(t, 201, send("PUT", "/sda/1/a/c/o2"))
+	require.Equal(t, 201, send("PUT", "/sda/2/a/c/o3"))
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, 204, send("DELETE", "/sda/2/a/c/o3"))
+	require.Equal(t, 404, send("DELETE", "/sda/3/a/c/o4"))
+
+	resp, err := ts.Do("GET", "/manifest/sda", nil)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	var manifest deviceManifest
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&manifest))
+	require.Equal(t, map[string]*partitionCounts{
+		"1": {Objects: 2},
+		"2": {Tombstones: 1},
+		"3": {Tombstones: 1},
+	}, manifest.Partitions)
+
+	resp, err = ts.Do("GET", "/manifest/sdb", nil)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	manifest = deviceManifest{}
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&manifest))
+	require.Equal(t, 0, len(manifest.Partitions))
+}
+
+func TestManifestCacheLocksPerDevice(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	c := &manifestCache{ttl: time.Minute, manifests: make(map[string]*cachedManifest)}
+	_, err = c.get(filepath.Join(dir, "sda", "objects"))
+	require.Nil(t, err)
+	// stand in for a long scan of sda
+	c.manifests[filepath.Join(dir, "sda", "objects")].Lock()
+	defer c.manifests[filepath.Join(dir, "sda", "objects")].Unlock()
+	done := make(chan error)
+	go func() {
+		_, err := c.get(filepath.Join(dir, "sdb", "objects"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		require.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("scan of sdb waited on sda")
+	}
+}
+
+func TestJSONErrorBodies(t *testing.T) {
+	ts, err := makeObjectServer("json_error_bodies", "true")
+	require.Nil(t, err)
+	defer ts.Close()
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/sda/0/a/c/missing", ts.host, ts.port), nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Trans-Id", "tx123")
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var body jsonError
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&body))
+	require.Equal(t, jsonError{Code: 404, Message: "Not Found", RequestID: "tx123"}, body)
+
+	resp, err = ts.Do("GET", "/healthcheck", nil)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+	data, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "OK", string(data))
+}
+
+func TestJSONErrorBodiesKeepReadyCheckProblems(t *testing.T) {
+	ts, err := makeObjectServer("json_error_bodies", "true")
+	require.Nil(t, err)
+	defer ts.Close()
+	oldGetRing := getRing
+	defer func() { getRing = oldGetRing }()
+	getRing = func(ringType, prefix, suffix string, policy int) (hummingbird.Ring, error) {
+		return nil, fmt.Errorf("no ring file")
+	}
+	resp, err := ts.Do("GET", "/readycheck", nil)
+	require.Nil(t, err)
+	require.Equal(t, 503, resp.StatusCode)
+	var status readiness
+	require.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+	require.False(t, status.Ready)
+	require.True(t, strings.HasPrefix(status.Problems[0], "Ring for policy 0 not loaded"))
+}
+
+func TestPlainErrorBodies(t *testing.T) {
+	ts, err := makeObjectServer()
+	require.Nil(t, err)
+	defer ts.Close()
+	resp, err := ts.Do("GET", "/sda/0/a/c/missing", nil)
+	require.Nil(t, err)
+	require.Equal(t, 404, resp.StatusCode)
+	require.Equal(t, "text/html", resp.Header.Get("Content-Type"))
+	data, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(string(data), "<html>"))
+}
+
+func TestReadyCheck(t *testing.T) {
+	ts, err := makeObjectServer()
+	require.Nil(t, err)
+	defer ts.Close()
+	ts.objServer.checkMounts = true
+
+	oldGetRing, oldIsMount := getRing, isMount
+	defer func() { getRing, isMount = oldGetRing, oldIsMount }()
+	ringLoaded, mounted := false, false
+	getRing = func(ringType, prefix, suffix string, policy int) (hummingbird.Ring, error) {
+		if !ringLoaded {
+			return nil, fmt.Errorf("no ring file")
+		}
+		return &localDeviceRing{device: "sda"}, nil
+	}
+	isMount = func(dir string) (bool, error) {
+		return mounted && filepath.Base(dir) == "sda", nil
+	}
+
+	ready := func() (int, readiness) {
+		resp, err := ts.Do("GET", "/readycheck", nil)
+		require.Nil(t, err)
+		var status readiness
+		require.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+		return resp.StatusCode, status
+	}
+	code, status := ready()
+	require.Equal(t, 503, code)
+	require.False(t, status.Ready)
+	require.True(t, strings.HasPrefix(status.Problems[0], "Ring for policy 0 not loaded"))
+
+	ringLoaded = true
+	code, status = ready()
+	require.Equal(t, 503, code)
+	require.Equal(t, []string{"No mounted devices for policy 0"}, status.Problems)
+
+	mounted = true
+	code, status = ready()
+	require.Equal(t, 200, code)
+	require.True(t, status.Ready)
+
+	resp, err := ts.Do("GET", "/healthcheck", nil)
+	require.Nil(t, err)
+	require.Equal(t, 200, resp.StatusCode)
+}
+
+func TestPutSlowUploadAborted(t *testing.T) {
+	ts, err := makeObjectServer("min_upload_rate", "1000", "min_upload_rate_grace", "0.1")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(ts.host, strconv.Itoa(ts.port)))
+	require.Nil(t, err)
+	defer conn.Close()
+	fmt.Fprintf(conn, "PUT /sda/0/a/c/o HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 1000\r\n"+
+		"X-Timestamp: %s\r\n\r\n", hummingbird.GetTimestamp())
+	go func() {
+		for i := 0; i < 1000; i++ {
+			if _, err := conn.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.Nil(t, err)
+	require.Equal(t, 408, resp.StatusCode)
+
+	hashDir := ObjHashDir(map[string]string{"device": "sda", "partition": "0", "account": "a", "container": "c", "obj": "o"},
+		ts.root, ts.objServer.hashPathPrefix, ts.objServer.hashPathSuffix, 0)
+	files, _ := hummingbird.ReadDirNames(hashDir)
+	require.Equal(t, 0, len(files))
+	tmpFiles, _ := hummingbird.ReadDirNames(filepath.Join(ts.root, "sda", "tmp"))
+	require.Equal(t, 0, len(tmpFiles))
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME DATA")))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Length", "9")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+}
+
+func TestPutStalledUploadAborted(t *testing.T) {
+	ts, err := makeObjectServer("min_upload_rate", "1000", "min_upload_rate_grace", "0.1")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(ts.host, strconv.Itoa(ts.port)))
+	require.Nil(t, err)
+	defer conn.Close()
+	// send a little of the body, then nothing more.
+	fmt.Fprintf(conn, "PUT /sda/0/a/c/o HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 1000\r\n"+
+		"X-Timestamp: %s\r\n\r\nxxxxxxxxxx", hummingbird.GetTimestamp())
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.Nil(t, err)
+	require.Equal(t, 408, resp.StatusCode)
+	require.True(t, resp.Close)
+
+	hashDir := ObjHashDir(map[string]string{"device": "sda", "partition": "0", "account": "a", "container": "c", "obj": "o"},
+		ts.root, ts.objServer.hashPathPrefix, ts.objServer.hashPathSuffix, 0)
+	var files []string
+	for i := 0; i < 100; i++ {
+		if files, _ = hummingbird.ReadDirNames(filepath.Join(ts.root, "sda", "tmp")); len(files) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 0, len(files))
+	files, _ = hummingbird.ReadDirNames(hashDir)
+	require.Equal(t, 0, len(files))
+}
+
+func TestPutSlowUploadIgnoresBandwidthLimit(t *testing.T) {
+	ts, err := makeObjectServer("min_upload_rate", "100000", "min_upload_rate_grace", "0.1", "account_bandwidth_limit", "20000")
+	require.Nil(t, err)
+	defer ts.Close()
+	// 30000 bytes at 20000/s after a second's worth of burst is well under min_upload_rate, but that's the server's doing.
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer(bytes.Repeat([]byte("x"), 30000)))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("Content-Length", "30000")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	require.Equal(t, 201, resp.StatusCode)
+}
+
+func TestPutNamingRules(t *testing.T) {
+	ts, err := makeObjectServer()
+	require.Nil(t, err)
+	defer ts.Close()
+	conf, err := hummingbird.StringConfig("[object-naming:a/c]\nmax_length=12\ndisallowed_chars=?#\nrequired_prefixes=img/, doc/\n")
+	require.Nil(t, err)
+	ts.objServer.namingRules, err = loadNamingRules(conf)
+	require.Nil(t, err)
+
+	put := func(path string) (int, string) {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d%s", ts.host, ts.port, path), bytes.NewBuffer([]byte("SOME DATA")))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Length", "9")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		return resp.StatusCode, strings.TrimSpace(string(body))
+	}
+	code, _ := put("/sda/0/a/c/img/cat.jpg")
+	assert.Equal(t, 201, code)
+	code, _ = put("/sda/0/a/c/doc/a")
+	assert.Equal(t, 201, code)
+	code, body := put("/sda/0/a/c/img/waytoolong.jpg")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Object name longer than 12 bytes", body)
+	code, body = put("/sda/0/a/c/img/a%3Fb")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, `Object name contains disallowed character '?'`, body)
+	code, body = put("/sda/0/a/c/tmp/a")
+	assert.Equal(t, 400, code)
+	assert.Equal(t, "Object name must start with one of: img/, doc/", body)
+	code, _ = put("/sda/0/a/other/tmp%3Fx")
+	assert.Equal(t, 201, code)
+}
+
+func TestLoadNamingRulesInvalidSection(t *testing.T) {
+	conf, err := hummingbird.StringConfig("[object-naming:a]\nmax_length=12\n")
+	require.Nil(t, err)
+	_, err = loadNamingRules(conf)
+	require.NotNil(t, err)
+}
+
+func TestAccountBandwidthLimit(t *testing.T) {
+	ts, err := makeObjectServer("account_bandwidth_limit", "20000")
+	require.Nil(t, err)
+	defer ts.Close()
+	ts.objServer.accountBandwidth["unlimited"] = 0
+	data := bytes.Repeat([]byte("x"), 30000)
+
+	transfer := func(account string) (time.Duration, time.Duration) {
+		start := time.Now()
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/%s/c/o", ts.host, ts.port, account), bytes.NewBuffer(data))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Length", "30000")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		require.Equal(t, 201, resp.StatusCode)
+		putTime := time.Since(start)
+
+		start = time.Now()
+		resp, err = ts.Do("GET", fmt.Sprintf("/sda/0/%s/c/o", account), nil)
+		require.Nil(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		require.Equal(t, data, body)
+		return putTime, time.Since(start)
+	}
+	// 30000 bytes at 20000/s, after a second's worth of burst
+	putTime, getTime := transfer("a")
+	assert.True(t, putTime >= 450*time.Millisecond)
+	assert.True(t, getTime >= 450*time.Millisecond)
+	putTime, getTime = transfer("unlimited")
+	assert.True(t, putTime < 300*time.Millisecond)
+	assert.True(t, getTime < 300*time.Millisecond)
+}
+
+func TestNotFoundReason(t *testing.T) {
+	ts, err := makeObjectServer()
+	require.Nil(t, err)
+	defer ts.Close()
+
+	deleteAt := time.Now().Unix() + 1
+	send := func(method, path string, headers map[string]string) *http.Response {
+		req, err := http.NewRequest(method, fmt.Sprintf("http://%s:%d%s", ts.host, ts.port, path), bytes.NewBuffer([]byte("SOME DATA")))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("Content-Length", "9")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		return resp
+	}
+	require.Equal(t, 201, send("PUT", "/sda/0/a/c/deleted", nil).StatusCode)
+	require.Equal(t, 204, send("DELETE", "/sda/0/a/c/deleted", nil).StatusCode)
+	require.Equal(t, 201, send("PUT", "/sda/0/a/c/expired", map[string]string{"X-Delete-At": strconv.FormatInt(deleteAt, 10)}).StatusCode)
+	require.Equal(t, 201, send("PUT", "/sda/0/a/c/live", nil).StatusCode)
+	time.Sleep(time.Until(time.Unix(deleteAt+1, 0)))
+
+	for _, method := range []string{"GET", "HEAD"} {
+		for obj, reason := range map[string]string{"missing": "missing", "deleted": "deleted", "expired": "expired"} {
+			resp, err := ts.Do(method, "/sda/0/a/c/"+obj, nil)
+			require.Nil(t, err)
+			require.Equal(t, 404, resp.StatusCode)
+			require.Equal(t, reason, resp.Header.Get("X-Backend-Not-Found-Reason"))
+		}
+		resp, err := ts.Do(method, "/sda/0/a/c/live", nil)
+		require.Nil(t, err)
+		require.Equal(t, 200, resp.StatusCode)
+		require.Equal(t, "", resp.Header.Get("X-Backend-Not-Found-Reason"))
+
+		// requests without any X-Backend-* headers aren't told why.
+		for _, obj := range []string{"missing", "deleted", "expired"} {
+			req, err := http.NewRequest(method, fmt.Sprintf("http://%s:%d/sda/0/a/c/%s", ts.host, ts.port, obj), nil)
+			require.Nil(t, err)
+			resp, err := http.DefaultClient.Do(req)
+			require.Nil(t, err)
+			require.Equal(t, 404, resp.StatusCode)
+			require.Equal(t, "", resp.Header.Get("X-Backend-Not-Found-Reason"))
+		}
+	}
+}
+
+func TestPutResumableUpload(t *testing.T) {
+	ts, err := makeObjectServer("resumable_uploads", "true")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(ts.host, strconv.Itoa(ts.port)))
+	require.Nil(t, err)
+	fmt.Fprintf(conn, "PUT /sda/0/a/c/o HTTP/1.1\r\nHost: localhost\r\nContent-Type: text/plain\r\nContent-Length: 10\r\n"+
+		"X-Upload-Token: abc\r\nX-Upload-Length: 10\r\nX-Upload-Offset: 0\r\nX-Timestamp: %s\r\n\r\nSOME", hummingbird.GetTimestamp())
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+
+	put := func(offset int, data string) *http.Response {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte(data)))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		req.Header.Set("X-Upload-Token", "abc")
+		req.Header.Set("X-Upload-Length", "10")
+		req.Header.Set("X-Upload-Offset", strconv.Itoa(offset))
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp = put(0, ""); resp.StatusCode == 409 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Equal(t, 409, resp.StatusCode)
+	require.Equal(t, "4", resp.Header.Get("X-Upload-Offset"))
+
+	resp = put(4, " DA")
+	require.Equal(t, 202, resp.StatusCode)
+	require.Equal(t, "7", resp.Header.Get("X-Upload-Offset"))
+	resp = put(7, "TA!")
+	require.Equal(t, 201, resp.StatusCode)
+	require.Equal(t, fmt.Sprintf("%x", md5.Sum([]byte("SOME DATA!"))), resp.Header.Get("ETag"))
+
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), nil)
+	require.Nil(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	require.Equal(t, "SOME DATA!", string(body))
+	uploads, _ := hummingbird.ReadDirNames(uploadsDir(ts.root, "sda"))
+	require.Equal(t, 0, len(uploads))
+}
+
+func TestPutResumableUploadKeptUntilCommit(t *testing.T) {
+	ts, err := makeObjectServer("resumable_uploads", "true")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	put := func(offset int, data string, etag string) *http.Response {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte(data)))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		req.Header.Set("X-Upload-Token", "abc")
+		req.Header.Set("X-Upload-Length", "10")
+		req.Header.Set("X-Upload-Offset", strconv.Itoa(offset))
+		if etag != "" {
+			req.Header.Set("ETag", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	require.Equal(t, 202, put(0, "SOME", "").StatusCode)
+	require.Equal(t, 422, put(4, " DATA!", "badetag").StatusCode)
+	uploads, _ := hummingbird.ReadDirNames(uploadsDir(ts.root, "sda"))
+	require.Equal(t, 1, len(uploads))
+
+	// the whole body is still staged, so the last request can be retried without it.
+	resp := put(10, "", fmt.Sprintf("%x", md5.Sum([]byte("SOME DATA!"))))
+	require.Equal(t, 201, resp.StatusCode)
+	uploads, _ = hummingbird.ReadDirNames(uploadsDir(ts.root, "sda"))
+	require.Equal(t, 0, len(uploads))
+}
+
+func TestRemoveAbandonedUploads(t *testing.T) {
+	ts, err := makeObjectServer("resumable_uploads", "true")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	put := func(token string, offset int, data string) *http.Response {
+		req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte(data)))
+		require.Nil(t, err)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+		req.Header.Set("X-Upload-Token", token)
+		req.Header.Set("X-Upload-Length", "10")
+		req.Header.Set("X-Upload-Offset", strconv.Itoa(offset))
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp
+	}
+	require.Equal(t, 202, put("old", 0, "SOME").StatusCode)
+	require.Equal(t, 202, put("new", 0, "SOME").StatusCode)
+	dir := uploadsDir(ts.root, "sda")
+	uploads, err := hummingbird.ReadDirNames(dir)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(uploads))
+	id := md5.Sum([]byte("/a/c/o/old"))
+	old := time.Now().Add(-2 * time.Hour)
+	require.Nil(t, os.Chtimes(filepath.Join(dir, hex.EncodeToString(id[:]), "data"), old, old))
+
+	require.Equal(t, 1, removeAbandonedUploads(dir, time.Hour))
+	uploads, err = hummingbird.ReadDirNames(dir)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(uploads))
+
+	resp := put("old", 4, "SOME")
+	require.Equal(t, 409, resp.StatusCode)
+	require.Equal(t, "0", resp.Header.Get("X-Upload-Offset"))
+	require.Equal(t, 202, put("new", 4, " DA").StatusCode)
+}
@@ -0,0 +1,115 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package objectserver
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/troubling/hummingbird/hummingbird"
+)
+
+// partitionCounts summarizes the object hash directories in a partition.
+type partitionCounts struct {
+	Objects    int64 `json:"objects"`
+	Tombstones int64 `json:"tombstones"`
+}
+
+// deviceManifest is a scan of a device's partitions for one policy.
+type deviceManifest struct {
+	Partitions map[string]*partitionCounts `json:"partitions"`
+	Scanned    float64                     `json:"scanned"`
+}
+
+// manifestCache holds recent device scans, so repeated requests don't each walk the whole device.
+type manifestCache struct {
+	sync.Mutex
+	ttl       time.Duration
+	manifests map[string]*cachedManifest
+}
+
+// cachedManifest is the latest scan of one objects directory; its lock is held across a rescan, so other devices aren't held up.
+type cachedManifest struct {
+	sync.Mutex
+	manifest *deviceManifest
+}
+
+// scanPartitions counts the objects and tombstones in each partition under objectsDir, using the newest file in each hash directory.
+func scanPartitions(objectsDir string) (*deviceManifest, error) {
+	manifest := &deviceManifest{Partitions: make(map[string]*partitionCounts), Scanned: float64(time.Now().UnixNano()) / 1e9}
+	partitions, err := hummingbird.ReadDirNames(objectsDir)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, partition := range partitions {
+		if _, err := strconv.ParseUint(partition, 10, 64); err != nil {
+			continue
+		}
+		counts := &partitionCounts{}
+		manifest.Partitions[partition] = counts
+		partitionDir := filepath.Join(objectsDir, partition)
+		suffixes, err := hummingbird.ReadDirNames(partitionDir)
+		if err != nil {
+			continue
+		}
+		for _, suffix := range suffixes {
+			if len(suffix) != 3 {
+				continue
+			}
+			hashes, err := hummingbird.ReadDirNames(filepath.Join(partitionDir, suffix))
+			if err != nil {
+				continue
+			}
+			for _, hash := range hashes {
+				dataFile, _ := ObjectFiles(filepath.Join(partitionDir, suffix, hash))
+				if strings.HasSuffix(dataFile, ".data") {
+					counts.Objects++
+				} else if strings.HasSuffix(dataFile, ".ts") {
+					counts.Tombstones++
+				}
+			}
+		}
+	}
+	return manifest, nil
+}
+
+// get returns the cached scan of objectsDir, rescanning it if it's older than the cache's ttl.
+func (c *manifestCache) get(objectsDir string) (*deviceManifest, error) {
+	c.Lock()
+	cached, ok := c.manifests[objectsDir]
+	if !ok {
+		cached = &cachedManifest{}
+		c.manifests[objectsDir] = cached
+	}
+	c.Unlock()
+	cached.Lock()
+	defer cached.Unlock()
+	if cached.manifest != nil && time.Since(time.Unix(0, int64(cached.manifest.Scanned*1e9))) < c.ttl {
+		return cached.manifest, nil
+	}
+	manifest, err := scanPartitions(objectsDir)
+	if err != nil {
+		return nil, err
+	}
+	cached.manifest = manifest
+	return manifest, nil
+}
@@ -0,0 +1,76 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package objectserver
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/troubling/hummingbird/hummingbird"
+)
+
+// namingRule restricts the names of objects PUT to one container.
+type namingRule struct {
+	maxLength        int
+	disallowedChars  string
+	requiredPrefixes []string
+}
+
+// loadNamingRules reads [object-naming:<account>/<container>] sections, keyed by "<account>/<container>".
+func loadNamingRules(serverconf hummingbird.Config) (map[string]*namingRule, error) {
+	rules := make(map[string]*namingRule)
+	for section := range serverconf.File {
+		if !strings.HasPrefix(section, "object-naming:") {
+			continue
+		}
+		container := strings.TrimPrefix(section, "object-naming:")
+		if parts := strings.Split(container, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid naming rule section %q, must be object-naming:<account>/<container>", section)
+		}
+		rule := &namingRule{
+			maxLength:       int(serverconf.GetInt(section, "max_length", 0)),
+			disallowedChars: serverconf.GetDefault(section, "disallowed_chars", ""),
+		}
+		for _, prefix := range strings.Split(serverconf.GetDefault(section, "required_prefixes", ""), ",") {
+			if prefix = strings.TrimSpace(prefix); prefix != "" {
+				rule.requiredPrefixes = append(rule.requiredPrefixes, prefix)
+			}
+		}
+		rules[container] = rule
+	}
+	return rules, nil
+}
+
+// check returns why obj breaks the rule, or "" if it doesn't.
+func (r *namingRule) check(obj string) string {
+	if r.maxLength > 0 && len(obj) > r.maxLength {
+		return fmt.Sprintf("Object name longer than %d bytes", r.maxLength)
+	}
+	if i := strings.IndexAny(obj, r.disallowedChars); i >= 0 {
+		c, _ := utf8.DecodeRuneInString(obj[i:])
+		return fmt.Sprintf("Object name contains disallowed character %q", c)
+	}
+	if len(r.requiredPrefixes) == 0 {
+		return ""
+	}
+	for _, prefix := range r.requiredPrefixes {
+		if strings.HasPrefix(obj, prefix) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("Object name must start with one of: %s", strings.Join(r.requiredPrefixes, ", "))
+}
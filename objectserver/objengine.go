@@ -29,6 +29,8 @@ var DriveFullError = errors.New("Drive Full")
 type Object interface {
 	// Exists determines whether or not there is an object to serve. Deleted objects do not exist, even if there is a tombstone.
 	Exists() bool
+	// Deleted determines whether the object's newest state is a tombstone, as opposed to never having existed.
+	Deleted() bool
 	// Quarantine removes the file's data, presumably after determining it's been corrupted.
 	Quarantine() error
 	// Metadata returns the object's metadata.  Will be nil if the object doesn't exist.
@@ -43,6 +45,8 @@ type Object interface {
 	SetData(size int64) (io.Writer, error)
 	// Commit saves a new object data that was started with SetData.
 	Commit(metadata map[string]string) error
+	// CommitMeta saves new metadata for the object without rewriting its data.
+	CommitMeta(metadata map[string]string) error
 	// Delete deletes the object.
 	Delete(metadata map[string]string) error
 	// Close releases any resources held by the Object instance.
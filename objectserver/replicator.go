@@ -28,6 +28,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/troubling/hummingbird/hummingbird"
@@ -129,6 +130,9 @@ type ReplicationDeviceStats struct {
 	DeviceStarted    time.Time
 	LastPassDuration time.Duration
 	TotalPasses      int64
+	// queue depths, updated with sync/atomic so they can be read without waiting on the stats loop.
+	PartitionsQueued    int64
+	PriorityJobsWaiting int64
 }
 
 type ReplicationDevice interface {
@@ -152,12 +156,13 @@ type replicationDevice struct {
 		listPartitions() ([]string, error)
 		replicatePartition(partition string)
 	}
-	r      *Replicator
-	dev    *hummingbird.Device
-	policy int
-	cancel chan struct{}
-	priRep chan PriorityRepJob
-	stats  ReplicationDeviceStats
+	r        *Replicator
+	dev      *hummingbird.Device
+	policy   int
+	cancel   chan struct{}
+	priRep   chan PriorityRepJob
+	stats    ReplicationDeviceStats
+	degraded bool
 }
 
 func (rd *replicationDevice) Stats() *ReplicationDeviceStats {
@@ -171,7 +176,9 @@ type statUpdate struct {
 }
 
 func (rd *replicationDevice) updateStat(stat string, amount int64) {
+	atomic.AddInt64(&rd.r.statUpdatesWaiting, 1)
 	rd.r.updateStat <- statUpdate{rd.Key(), stat, amount}
+	atomic.AddInt64(&rd.r.statUpdatesWaiting, -1)
 }
 
 type beginReplicationResponse struct {
@@ -189,6 +196,9 @@ func (rd *replicationDevice) listObjFiles(objChan chan string, cancel chan struc
 		return
 	}
 	if len(suffixDirs) == 0 {
+		if rd.r.dryRun {
+			return
+		}
 		os.Remove(filepath.Join(partdir, ".lock"))
 		os.Remove(filepath.Join(partdir, "hashes.pkl"))
 		os.Remove(filepath.Join(partdir, "hashes.invalid"))
@@ -209,13 +219,17 @@ func (rd *replicationDevice) listObjFiles(objChan chan string, cancel chan struc
 			return
 		}
 		if len(hashDirs) == 0 {
-			os.Remove(suffDir)
+			if !rd.r.dryRun {
+				os.Remove(suffDir)
+			}
 			continue
 		}
 		for _, hashDir := range hashDirs {
 			fileList, err := filepath.Glob(filepath.Join(hashDir, "*.[tdm]*"))
 			if len(fileList) == 0 {
-				os.Remove(hashDir)
+				if !rd.r.dryRun {
+					os.Remove(hashDir)
+				}
 				continue
 			}
 			if err != nil {
@@ -245,8 +259,11 @@ func (rd *replicationDevice) syncFile(objFile string, dst []*syncFileArg) (syncs
 	fp, xattrs, fileSize, err := getFile(objFile)
 	if _, ok := err.(quarantineFileError); ok {
 		hashDir := filepath.Dir(objFile)
-		rd.r.LogError("[syncFile] %s failed audit and is being quarantined: %s", hashDir, err.Error())
-		QuarantineHash(hashDir)
+		if QuarantineHash(hashDir) == nil {
+			rd.r.LogError("[syncFile] %s failed audit and is being quarantined: %s", hashDir, err.Error())
+		} else {
+			rd.r.LogError("[syncFile] %s failed audit but can't be quarantined: %s", hashDir, err.Error())
+		}
 		return 0, 0, nil
 	} else if err != nil {
 		return 0, 0, nil
@@ -392,6 +409,7 @@ func (rd *replicationDevice) replicateLocal(partition string, nodes []*hummingbi
 		return false
 	})
 	startSyncing := time.Now()
+	estimate := transferEstimate{}
 	for objFile := range objChan {
 		toSync := make([]*syncFileArg, 0)
 		suffix := filepath.Base(filepath.Dir(filepath.Dir(objFile)))
@@ -403,6 +421,10 @@ func (rd *replicationDevice) replicateLocal(partition string, nodes []*hummingbi
 				toSync = append(toSync, &syncFileArg{conn: remoteConnections[dev.Id], dev: dev})
 			}
 		}
+		if rd.r.dryRun {
+			estimate.add(objFile, len(toSync))
+			continue
+		}
 		if syncs, _, err := rd.i.syncFile(objFile, toSync); err == nil {
 			syncCount += syncs
 		} else {
@@ -416,6 +438,10 @@ func (rd *replicationDevice) replicateLocal(partition string, nodes []*hummingbi
 		}
 	}
 	timeSyncing := float64(time.Now().Sub(startSyncing)) / float64(time.Second)
+	if rd.r.dryRun {
+		rd.reportEstimate("replicateLocal", path, estimate)
+		return
+	}
 	if syncCount > 0 {
 		rd.r.LogInfo("[replicateLocal] Partition %s synced %d files (%.2fs / %.2fs / %.2fs)", path, syncCount, timeGetHashesRemote, timeGetHashesLocal, timeSyncing)
 	}
@@ -423,6 +449,18 @@ func (rd *replicationDevice) replicateLocal(partition string, nodes []*hummingbi
 
 func (rd *replicationDevice) replicateHandoff(partition string, nodes []*hummingbird.Device) {
 	path := filepath.Join(rd.r.deviceRoot, rd.dev.Device, PolicyDir(rd.policy), partition)
+	if rd.r.dryRun {
+		estimate := transferEstimate{}
+		objChan := make(chan string, 100)
+		cancel := make(chan struct{})
+		defer close(cancel)
+		go rd.i.listObjFiles(objChan, cancel, path, func(string) bool { return true })
+		for objFile := range objChan {
+			estimate.add(objFile, len(nodes))
+		}
+		rd.reportEstimate("replicateHandoff", path, estimate)
+		return
+	}
 	syncCount := 0
 	remoteConnections := make(map[int]RepConn)
 	rChan := make(chan beginReplicationResponse)
@@ -476,11 +514,38 @@ func (rd *replicationDevice) replicateHandoff(partition string, nodes []*humming
 	}
 }
 
+// transferEstimate tallies what a dry run would have sent for a partition.
+type transferEstimate struct {
+	Files int64
+	Bytes int64
+}
+
+func (t *transferEstimate) add(objFile string, destinations int) {
+	if destinations == 0 {
+		return
+	}
+	if finfo, err := os.Stat(objFile); err == nil {
+		t.Files += int64(destinations)
+		t.Bytes += int64(destinations) * finfo.Size()
+	}
+}
+
+func (rd *replicationDevice) reportEstimate(caller string, path string, estimate transferEstimate) {
+	if estimate.Files > 0 {
+		rd.r.LogInfo("[%s] Dry run: partition %s would send %d files (%d bytes)", caller, path, estimate.Files, estimate.Bytes)
+		rd.updateStat("DryRunFiles", estimate.Files)
+		rd.updateStat("DryRunBytes", estimate.Bytes)
+	}
+}
+
 func (rd *replicationDevice) Key() string {
 	return deviceKey(rd.dev, rd.policy)
 }
 
 func (rd *replicationDevice) cleanTemp() {
+	if rd.r.dryRun {
+		return
+	}
 	tempDir := TempDirPath(rd.r.deviceRoot, rd.dev.Device)
 	if tmpContents, err := ioutil.ReadDir(tempDir); err == nil {
 		for _, tmpEntry := range tmpContents {
@@ -543,6 +608,7 @@ func (rd *replicationDevice) Replicate() {
 		return
 	}
 
+	rd.checkSmart()
 	rd.i.cleanTemp()
 
 	partitionList, err := rd.i.listPartitions()
@@ -554,6 +620,8 @@ func (rd *replicationDevice) Replicate() {
 		return
 	}
 	rd.updateStat("PartitionsTotal", int64(len(partitionList)))
+	atomic.StoreInt64(&rd.stats.PartitionsQueued, int64(len(partitionList)))
+	defer atomic.StoreInt64(&rd.stats.PartitionsQueued, 0)
 
 	for _, partition := range partitionList {
 		rd.updateStat("checkin", 1)
@@ -567,10 +635,40 @@ func (rd *replicationDevice) Replicate() {
 		}
 		rd.processPriorityJobs()
 		rd.i.replicatePartition(partition)
+		atomic.AddInt64(&rd.stats.PartitionsQueued, -1)
+		if rd.degraded {
+			select {
+			case <-rd.cancel:
+				rd.r.LogError("replicateDevice canceled for device: %s", rd.dev.Device)
+				return
+			case <-time.After(rd.r.smartSleepTime):
+			}
+		}
 	}
 	rd.updateStat("FullReplicateCount", 1)
 }
 
+// checkSmart samples the drive's SMART attributes, if enabled, and flags the device as degraded if it shows pre-failure indicators.
+func (rd *replicationDevice) checkSmart() {
+	if rd.r.smart == nil {
+		return
+	}
+	attrs, err := rd.r.smart.Attributes(filepath.Join(rd.r.deviceRoot, rd.dev.Device))
+	if err != nil {
+		rd.r.LogError("[checkSmart] Unable to read SMART attributes for %s: %v", rd.dev.Device, err)
+		return
+	}
+	for _, attr := range smartPreFailAttributes {
+		if value, ok := attrs[attr]; ok {
+			rd.updateStat("Smart"+attr, value)
+		}
+	}
+	if rd.degraded = smartDegraded(attrs); rd.degraded {
+		rd.r.LogError("[checkSmart] Drive %s shows SMART pre-failure indicators; replicating it at reduced priority", rd.dev.Device)
+		rd.updateStat("SmartDegraded", 1)
+	}
+}
+
 func (rd *replicationDevice) Cancel() {
 	close(rd.cancel)
 }
@@ -594,6 +692,8 @@ func (n *NoMoreNodes) Next() *hummingbird.Device {
 }
 
 func (rd *replicationDevice) PriorityReplicate(pri PriorityRepJob, timeout time.Duration) bool {
+	atomic.AddInt64(&rd.stats.PriorityJobsWaiting, 1)
+	defer atomic.AddInt64(&rd.stats.PriorityJobsWaiting, -1)
 	timer := time.NewTimer(timeout)
 	defer timer.Stop()
 	select {
@@ -672,23 +772,32 @@ type Replicator struct {
 	port               int
 	bindIp             string
 	Rings              map[int]replicationRing
+	missingRings       map[int]bool
+	hashPathPrefix     string
+	hashPathSuffix     string
 	runningDevices     map[string]ReplicationDevice
 	cancelCounts       map[string]int64
 	runningDevicesLock sync.Mutex
+	deviceStats        atomic.Value // map[string]*ReplicationDeviceStats, republished whenever runningDevices changes
 	devices            map[string]bool
 	partitions         map[string]bool
 	concurrency        int
 	concurrencySem     chan struct{}
 	updateStat         chan statUpdate
+	statUpdatesWaiting int64
 	reclaimAge         int64
 	quorumDelete       bool
+	dryRun             bool
 	reserve            int64
+	inodeReserve       int64
 	replicationMan     *ReplicationManager
 	replicateTimeout   time.Duration
 	onceDone           chan struct{}
 	onceWaiting        int64
 	loopSleepTime      time.Duration
 	partSleepTime      time.Duration
+	smart              smartSource
+	smartSleepTime     time.Duration
 }
 
 func (r *Replicator) cancelStalledDevices() {
@@ -702,11 +811,38 @@ func (r *Replicator) cancelStalledDevices() {
 			delete(r.runningDevices, key)
 		}
 	}
+	r.publishDeviceStats()
+}
+
+// publishDeviceStats snapshots the running devices' stats for queueDepths, so it doesn't need runningDevicesLock.
+// It must be called with the lock held, after runningDevices changes.
+func (r *Replicator) publishDeviceStats() {
+	stats := make(map[string]*ReplicationDeviceStats, len(r.runningDevices))
+	for key, rd := range r.runningDevices {
+		stats[key] = rd.Stats()
+	}
+	r.deviceStats.Store(stats)
+}
+
+var getRing = hummingbird.GetRing
+
+// loadMissingRings retries loading the rings for any policies whose ring files weren't available yet.
+func (r *Replicator) loadMissingRings() {
+	for policy := range r.missingRings {
+		ring, err := getRing("object", r.hashPathPrefix, r.hashPathSuffix, policy)
+		if err != nil {
+			continue
+		}
+		r.LogInfo("Loaded ring for Policy %d.", policy)
+		r.Rings[policy] = ring
+		delete(r.missingRings, policy)
+	}
 }
 
 func (r *Replicator) verifyRunningDevices() {
 	r.runningDevicesLock.Lock()
 	defer r.runningDevicesLock.Unlock()
+	r.loadMissingRings()
 	expectedDevices := make(map[string]bool)
 	for policy, ring := range r.Rings {
 		ringDevices, err := ring.LocalDevices(r.port)
@@ -733,6 +869,7 @@ func (r *Replicator) verifyRunningDevices() {
 			delete(r.runningDevices, key)
 		}
 	}
+	r.publishDeviceStats()
 }
 
 func (r *Replicator) reportStats() {
@@ -741,6 +878,7 @@ func (r *Replicator) reportStats() {
 	var totalDuration time.Duration
 	var maxLastPass time.Time
 	var doneParts, totalParts int64
+	var dryRunFiles, dryRunBytes int64
 	var processingTime float64
 	allHaveCompleted := true
 	for _, rd := range r.runningDevices {
@@ -754,6 +892,8 @@ func (r *Replicator) reportStats() {
 		totalDuration += stats.LastPassDuration
 		totalParts += stats.Stats["PartitionsTotal"]
 		doneParts += stats.Stats["PartitionsDone"]
+		dryRunFiles += stats.Stats["DryRunFiles"]
+		dryRunBytes += stats.Stats["DryRunBytes"]
 		processingTime += time.Since(stats.RunStarted).Seconds()
 	}
 	if processingTime > 0 {
@@ -773,6 +913,10 @@ func (r *Replicator) reportStats() {
 			processingTime, partsPerSecond, remainingStr)
 	}
 
+	if r.dryRun {
+		r.LogInfo("Dry run: %d files (%d bytes) would be sent", dryRunFiles, dryRunBytes)
+	}
+
 	if allHaveCompleted {
 		hummingbird.DumpReconCache(r.reconCachePath, "object",
 			map[string]interface{}{
@@ -813,6 +957,24 @@ func (r *Replicator) getDeviceProgress() map[string]map[string]interface{} {
 	return deviceProgress
 }
 
+// queueDepths reports how much work is waiting in each of the replicator's queues.
+func (r *Replicator) queueDepths() map[string]interface{} {
+	deviceStats, _ := r.deviceStats.Load().(map[string]*ReplicationDeviceStats)
+	devices := make(map[string]map[string]int64, len(deviceStats))
+	for key, stats := range deviceStats {
+		devices[key] = map[string]int64{
+			"partitions_queued":     atomic.LoadInt64(&stats.PartitionsQueued),
+			"priority_jobs_waiting": atomic.LoadInt64(&stats.PriorityJobsWaiting),
+		}
+	}
+	return map[string]interface{}{
+		"stat_updates_waiting":     atomic.LoadInt64(&r.statUpdatesWaiting),
+		"replication_slots_in_use": len(r.concurrencySem),
+		"replication_slots":        cap(r.concurrencySem),
+		"devices":                  devices,
+	}
+}
+
 func (r *Replicator) runLoopCheck(reportTimer <-chan time.Time) {
 	select {
 	case update := <-r.updateStat:
@@ -855,6 +1017,7 @@ func (r *Replicator) RunForever() {
 
 // Run a single replication pass. (NOTE: we will prob get rid of this because of priorityRepl)
 func (r *Replicator) Run() {
+	r.loadMissingRings()
 	for policy, ring := range r.Rings {
 		devices, err := ring.LocalDevices(r.port)
 		if err != nil {
@@ -872,6 +1035,7 @@ func (r *Replicator) Run() {
 			}(dev, key)
 		}
 	}
+	r.publishDeviceStats()
 	for r.onceWaiting > 0 {
 		r.runLoopCheck(make(chan time.Time))
 	}
@@ -906,6 +1070,7 @@ func NewReplicator(serverconf hummingbird.Config, flags *flag.FlagSet) (hummingb
 		runningDevices:   make(map[string]ReplicationDevice),
 		cancelCounts:     make(map[string]int64),
 		reserve:          serverconf.GetInt("object-replicator", "fallocate_reserve", 0),
+		inodeReserve:     serverconf.GetInt("object-replicator", "inode_reserve", 0),
 		replicationMan:   NewReplicationManager(serverconf.GetLimit("object-replicator", "replication_limit", 3, 100)),
 		replicateTimeout: time.Minute, // TODO(redbo): does this need to be configurable?
 		reconCachePath:   serverconf.GetDefault("object-replicator", "recon_cache_path", "/var/cache/swift"),
@@ -914,9 +1079,11 @@ func NewReplicator(serverconf hummingbird.Config, flags *flag.FlagSet) (hummingb
 		port:             int(serverconf.GetInt("object-replicator", "bind_port", 6500)),
 		bindIp:           serverconf.GetDefault("object-replicator", "bind_ip", "0.0.0.0"),
 		quorumDelete:     serverconf.GetBool("object-replicator", "quorum_delete", false),
+		dryRun:           serverconf.GetBool("object-replicator", "dry_run", false),
 		reclaimAge:       int64(serverconf.GetInt("object-replicator", "reclaim_age", int64(hummingbird.ONE_WEEK))),
 		logLevel:         serverconf.GetDefault("object-replicator", "log_level", "INFO"),
 		Rings:            make(map[int]replicationRing),
+		missingRings:     make(map[int]bool),
 		concurrency:      concurrency,
 		concurrencySem:   make(chan struct{}, concurrency),
 		updateStat:       make(chan statUpdate),
@@ -925,23 +1092,38 @@ func NewReplicator(serverconf hummingbird.Config, flags *flag.FlagSet) (hummingb
 		onceDone:         make(chan struct{}),
 		loopSleepTime:    time.Second * 30,
 		partSleepTime:    time.Duration(serverconf.GetInt("object-replicator", "ms_per_part", 100)) * time.Millisecond,
+		smartSleepTime:   time.Duration(serverconf.GetInt("object-replicator", "smart_degraded_ms_per_part", 1000)) * time.Millisecond,
+	}
+	if serverconf.GetBool("object-replicator", "smart_check", false) {
+		replicator.smart = &smartctlSource{
+			smartctl: serverconf.GetDefault("object-replicator", "smartctl", "smartctl"),
+			mounts:   "/proc/mounts",
+		}
 	}
 
-	hashPathPrefix, hashPathSuffix, err := hummingbird.GetHashPrefixAndSuffix()
+	var err error
+	replicator.hashPathPrefix, replicator.hashPathSuffix, err = hummingbird.GetHashPrefixAndSuffix()
 	if err != nil {
 		return nil, fmt.Errorf("Unable to get hash prefix and suffix")
 	}
+	if replicator.logger, err = hummingbird.SetupLogger(serverconf, flags, "app:object-replicator", "object-replicator"); err != nil {
+		return nil, fmt.Errorf("Error setting up logger: %v", err)
+	}
+	setQuarantineLimit(serverconf.GetInt("object-replicator", "quarantine_limit", 0),
+		time.Duration(serverconf.GetFloat("object-replicator", "quarantine_limit_interval", 3600)*float64(time.Second)),
+		replicator.logger, quarantineStats(serverconf, "object-replicator", "objectreplicator"))
 	for _, policy := range hummingbird.LoadPolicies() {
 		if policy.Type != "replication" {
 			continue
 		}
-		if replicator.Rings[policy.Index], err = hummingbird.GetRing("object", hashPathPrefix, hashPathSuffix, policy.Index); err != nil {
-			return nil, fmt.Errorf("Unable to load ring for Policy %d.", policy.Index)
+		// a missing ring is retried each time the running devices are verified, so policies can be added while running.
+		if ring, err := getRing("object", replicator.hashPathPrefix, replicator.hashPathSuffix, policy.Index); err != nil {
+			replicator.LogError("Unable to load ring for Policy %d, will retry: %v", policy.Index, err)
+			replicator.missingRings[policy.Index] = true
+		} else {
+			replicator.Rings[policy.Index] = ring
 		}
 	}
-	if replicator.logger, err = hummingbird.SetupLogger(serverconf, flags, "app:object-replicator", "object-replicator"); err != nil {
-		return nil, fmt.Errorf("Error setting up logger: %v", err)
-	}
 	devices_flag := flags.Lookup("devices")
 	if devices_flag != nil {
 		if devices := devices_flag.Value.(flag.Getter).Get().(string); len(devices) > 0 {
@@ -964,6 +1146,12 @@ func NewReplicator(serverconf hummingbird.Config, flags *flag.FlagSet) (hummingb
 			replicator.quorumDelete = true
 		}
 	}
+	if !replicator.dryRun {
+		dryRunFlag := flags.Lookup("dry-run")
+		if dryRunFlag != nil && dryRunFlag.Value.(flag.Getter).Get() == true {
+			replicator.dryRun = true
+		}
+	}
 	if serverconf.GetBool("object-replicator", "vm_test_mode", false) { // slow down the replicator in saio mode
 		replicator.partSleepTime = time.Duration(serverconf.GetInt("object-replicator", "ms_per_part", 500)) * time.Millisecond
 	}
@@ -664,6 +664,78 @@ func TestReplicateHandoff(t *testing.T) {
 	require.False(t, hummingbird.Exists(filename))
 }
 
+func TestReplicateLocalDryRun(t *testing.T) {
+	deviceRoot, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(deviceRoot)
+	replicator, err := newTestReplicator("bind_port", "1234", "check_mounts", "no", "dry_run", "true")
+	require.Nil(t, err)
+	replicator.deviceRoot = deviceRoot
+	partition := "1"
+	partPath := filepath.Join(deviceRoot, "sda", "objects", partition)
+	for _, suffix := range []string{"aaa", "bbb"} {
+		filename := filepath.Join(partPath, suffix, "00000000000000000000000000000"+suffix, "1472940619.68559.data")
+		require.Nil(t, os.MkdirAll(filepath.Dir(filename), 0777))
+		require.Nil(t, ioutil.WriteFile(filename, []byte("SOME DATA"), 0666))
+	}
+	rd := newPatchableReplicationDevice(replicator)
+	rd.dev = &hummingbird.Device{Device: "sda"}
+	hashes, err := GetHashes(deviceRoot, "sda", partition, nil, replicator.reclaimAge, 0, replicator)
+	require.Nil(t, err)
+	remoteDev := &hummingbird.Device{Id: 1, Device: "sda"}
+	var sent []interface{}
+	rd._beginReplication = func(dev *hummingbird.Device, partition string, needHashes bool, rChan chan beginReplicationResponse) {
+		conn := &mockRepConn{_SendMessage: func(v interface{}) error {
+			sent = append(sent, v)
+			return nil
+		}}
+		rChan <- beginReplicationResponse{dev: remoteDev, hashes: map[string]string{"aaa": hashes["aaa"], "bbb": "different"}, conn: conn}
+	}
+	rd._syncFile = func(objFile string, dst []*syncFileArg) (syncs int, insync int, err error) {
+		t.Fatal("syncFile called during dry run")
+		return 0, 0, nil
+	}
+	rd.replicateLocal(partition, []*hummingbird.Device{remoteDev}, &NoMoreNodes{})
+	require.Equal(t, []interface{}{SyncFileRequest{Done: true}}, sent)
+	stats := map[string]int64{}
+	for len(replicator.updateStat) > 0 {
+		update := <-replicator.updateStat
+		stats[update.stat] += update.value
+	}
+	require.Equal(t, int64(1), stats["DryRunFiles"])
+	require.Equal(t, int64(9), stats["DryRunBytes"])
+}
+
+func TestReplicateHandoffDryRun(t *testing.T) {
+	deviceRoot, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(deviceRoot)
+	replicator, err := newTestReplicator("bind_port", "1234", "check_mounts", "no", "dry_run", "true")
+	require.Nil(t, err)
+	partition := "1"
+	filename := filepath.Join(deviceRoot, "objects", partition, "aaa", "00000000000000000000000000000000", "1472940619.68559.data")
+	require.Nil(t, os.MkdirAll(filepath.Dir(filename), 0777))
+	require.Nil(t, ioutil.WriteFile(filename, []byte("SOME DATA"), 0666))
+	rd := newPatchableReplicationDevice(replicator)
+	rd._beginReplication = func(dev *hummingbird.Device, partition string, hashes bool, rChan chan beginReplicationResponse) {
+		t.Fatal("beginReplication called during dry run")
+	}
+	rd._listObjFiles = func(objChan chan string, cancel chan struct{}, partdir string, needSuffix func(string) bool) {
+		objChan <- filename
+		close(objChan)
+	}
+	nodes := []*hummingbird.Device{{Id: 1, Device: "sda"}, {Id: 2, Device: "sda"}}
+	rd.replicateHandoff(partition, nodes)
+	require.True(t, hummingbird.Exists(filename))
+	stats := map[string]int64{}
+	for len(replicator.updateStat) > 0 {
+		update := <-replicator.updateStat
+		stats[update.stat] += update.value
+	}
+	require.Equal(t, int64(2), stats["DryRunFiles"])
+	require.Equal(t, int64(18), stats["DryRunBytes"])
+}
+
 func TestCleanTemp(t *testing.T) {
 	deviceRoot, err := ioutil.TempDir("", "")
 	require.Nil(t, err)
@@ -687,6 +759,38 @@ func TestCleanTemp(t *testing.T) {
 	require.True(t, hummingbird.Exists(filepath.Join(tmpDir, "testfile1")))
 }
 
+func TestDryRunLeavesFiles(t *testing.T) {
+	deviceRoot, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(deviceRoot)
+	replicator, err := newTestReplicator("bind_port", "1234", "check_mounts", "no", "devices", deviceRoot, "dry_run", "true")
+	require.Nil(t, err)
+	rd := newPatchableReplicationDevice(replicator)
+	rd.dev.Device = "sda"
+	tmpDir := filepath.Join(deviceRoot, "sda", "tmp")
+	require.Nil(t, os.MkdirAll(tmpDir, 0777))
+	file, err := os.Create(filepath.Join(tmpDir, "testfile"))
+	require.Nil(t, err)
+	file.Close()
+	oldTime := time.Now().Add(-(time.Hour * 24 * 14))
+	require.Nil(t, os.Chtimes(filepath.Join(tmpDir, "testfile"), oldTime, oldTime))
+	rd.cleanTemp()
+	require.True(t, hummingbird.Exists(filepath.Join(tmpDir, "testfile")))
+
+	partDir := filepath.Join(deviceRoot, "sda", "objects", "1")
+	hashDir := filepath.Join(partDir, "abc", "fffffffffffffffffffffffffffffabc")
+	require.Nil(t, os.MkdirAll(hashDir, 0777))
+	require.Nil(t, os.MkdirAll(filepath.Join(partDir, "def"), 0777))
+	objChan := make(chan string)
+	cancel := make(chan struct{})
+	defer close(cancel)
+	go rd.listObjFiles(objChan, cancel, partDir, func(string) bool { return true })
+	for range objChan {
+	}
+	require.True(t, hummingbird.Exists(hashDir))
+	require.True(t, hummingbird.Exists(filepath.Join(partDir, "def")))
+}
+
 func TestReplicate(t *testing.T) {
 	replicator, err := newTestReplicator("bind_port", "1234", "check_mounts", "no")
 	require.Nil(t, err)
@@ -702,6 +806,91 @@ func TestReplicate(t *testing.T) {
 	require.Equal(t, []string{"1", "2", "3"}, calledWith)
 }
 
+func TestQueueDepths(t *testing.T) {
+	replicator, err := newTestReplicator("bind_port", "1234", "check_mounts", "no")
+	require.Nil(t, err)
+	rd := newPatchableReplicationDevice(replicator)
+	replicator.runningDevices = map[string]ReplicationDevice{rd.Key(): rd}
+	replicator.publishDeviceStats()
+	deviceDepth := func(name string) int64 {
+		return replicator.queueDepths()["devices"].(map[string]map[string]int64)[rd.Key()][name]
+	}
+	rd._listPartitions = func() ([]string, error) {
+		return []string{"1", "2", "3"}, nil
+	}
+	queued := []int64{}
+	rd._replicatePartition = func(partition string) {
+		queued = append(queued, deviceDepth("partitions_queued"))
+	}
+	rd.Replicate()
+	require.Equal(t, []int64{3, 2, 1}, queued)
+	require.Equal(t, int64(0), deviceDepth("partitions_queued"))
+
+	done := make(chan bool)
+	go func() {
+		done <- rd.PriorityReplicate(PriorityRepJob{}, time.Second)
+	}()
+	for i := 0; i < 100 && deviceDepth("priority_jobs_waiting") == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	require.Equal(t, int64(1), deviceDepth("priority_jobs_waiting"))
+	<-rd.priRep
+	require.True(t, <-done)
+	require.Equal(t, int64(0), deviceDepth("priority_jobs_waiting"))
+
+	replicator.concurrencySem <- struct{}{}
+	depths := replicator.queueDepths()
+	require.Equal(t, 1, depths["replication_slots_in_use"])
+	require.Equal(t, cap(replicator.concurrencySem), depths["replication_slots"])
+	require.Equal(t, int64(0), depths["stat_updates_waiting"])
+
+	// reading the depths doesn't wait on the stats loop or device changes.
+	replicator.runningDevicesLock.Lock()
+	defer replicator.runningDevicesLock.Unlock()
+	require.Equal(t, int64(0), deviceDepth("partitions_queued"))
+}
+
+type mockSmartSource map[string]map[string]int64
+
+func (m mockSmartSource) Attributes(devicePath string) (map[string]int64, error) {
+	return m[filepath.Base(devicePath)], nil
+}
+
+func TestReplicateSmartDegraded(t *testing.T) {
+	replicator, err := newTestReplicator("bind_port", "1234", "smart_degraded_ms_per_part", "20")
+	require.Nil(t, err)
+	replicator.smart = mockSmartSource{
+		"sda": {"Reallocated_Sector_Ct": 0, "Current_Pending_Sector": 0},
+		"sdb": {"Reallocated_Sector_Ct": 12, "Current_Pending_Sector": 0},
+	}
+	replicate := func(device string) (time.Duration, map[string]int64) {
+		rd := newPatchableReplicationDevice(replicator)
+		rd.dev = &hummingbird.Device{Device: device}
+		rd._listPartitions = func() ([]string, error) {
+			return []string{"1", "2", "3"}, nil
+		}
+		rd._replicatePartition = func(partition string) {}
+		start := time.Now()
+		rd.Replicate()
+		elapsed := time.Since(start)
+		stats := map[string]int64{}
+		for len(replicator.updateStat) > 0 {
+			update := <-replicator.updateStat
+			stats[update.stat] += update.value
+		}
+		return elapsed, stats
+	}
+
+	elapsed, stats := replicate("sda")
+	require.True(t, elapsed < 60*time.Millisecond)
+	require.Equal(t, int64(0), stats["SmartDegraded"])
+
+	elapsed, stats = replicate("sdb")
+	require.True(t, elapsed >= 60*time.Millisecond)
+	require.Equal(t, int64(1), stats["SmartDegraded"])
+	require.Equal(t, int64(12), stats["SmartReallocated_Sector_Ct"])
+}
+
 func TestCancelReplicate(t *testing.T) {
 	replicator, err := newTestReplicator("bind_port", "1234", "check_mounts", "no")
 	require.Nil(t, err)
@@ -909,6 +1098,60 @@ func TestVerifyDevices(t *testing.T) {
 	require.True(t, canceled)
 }
 
+// localDeviceRing is a ring with a single local device.
+type localDeviceRing struct {
+	hummingbird.Ring
+	device string
+}
+
+func (r *localDeviceRing) LocalDevices(localPort int) (devs []*hummingbird.Device, err error) {
+	return []*hummingbird.Device{{Device: r.device}}, nil
+}
+
+func TestVerifyDevicesPolicyRings(t *testing.T) {
+	oldLoadPolicies := hummingbird.LoadPolicies
+	oldGetRing := getRing
+	oldNewReplicationDevice := newReplicationDevice
+	defer func() {
+		hummingbird.LoadPolicies = oldLoadPolicies
+		getRing = oldGetRing
+		newReplicationDevice = oldNewReplicationDevice
+	}()
+	hummingbird.LoadPolicies = func() hummingbird.PolicyList {
+		return hummingbird.PolicyList{
+			0: &hummingbird.Policy{Index: 0, Type: "replication", Name: "gold", Default: true},
+			1: &hummingbird.Policy{Index: 1, Type: "replication", Name: "silver"},
+		}
+	}
+	rings := map[int]hummingbird.Ring{0: &localDeviceRing{device: "sda"}}
+	getRing = func(ringType, prefix, suffix string, policy int) (hummingbird.Ring, error) {
+		if ring, ok := rings[policy]; ok {
+			return ring, nil
+		}
+		return nil, fmt.Errorf("Error loading object:%d ring", policy)
+	}
+	started := make(map[string]int)
+	newReplicationDevice = func(dev *hummingbird.Device, policy int, r *Replicator) *replicationDevice {
+		started[dev.Device] = policy
+		return oldNewReplicationDevice(dev, policy, r)
+	}
+
+	replicator, err := newTestReplicator("bind_port", "1234", "check_mounts", "no")
+	require.Nil(t, err)
+	require.True(t, replicator.missingRings[1])
+	replicator.verifyRunningDevices()
+	require.Equal(t, map[string]int{"sda": 0}, started)
+
+	rings[1] = &localDeviceRing{device: "sdb"}
+	replicator.verifyRunningDevices()
+	require.Equal(t, map[string]int{"sda": 0, "sdb": 1}, started)
+	require.Equal(t, 0, len(replicator.missingRings))
+	require.Equal(t, 2, len(replicator.runningDevices))
+	for _, rd := range replicator.runningDevices {
+		rd.Cancel()
+	}
+}
+
 type replicationLogSaver struct {
 	logged []string
 }
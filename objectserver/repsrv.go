@@ -102,6 +102,18 @@ func (r *Replicator) ProgressReportHandler(w http.ResponseWriter, req *http.Requ
 	return
 }
 
+// QueueDepthHandler handles HTTP requests for the depths of the replicator's work queues
+func (r *Replicator) QueueDepthHandler(w http.ResponseWriter, req *http.Request) {
+	data, err := json.Marshal(r.queueDepths())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
 // priorityRepHandler handles HTTP requests for priority replications jobs.
 func (r *Replicator) priorityRepHandler(w http.ResponseWriter, req *http.Request) {
 	var pri PriorityRepJob
@@ -231,7 +243,7 @@ func (r *Replicator) objRepConnHandler(writer http.ResponseWriter, request *http
 				return "creating file writer", err
 			}
 			defer tempFile.Abandon()
-			if err := tempFile.Preallocate(sfr.Size, r.reserve); err != nil {
+			if err := tempFile.Preallocate(sfr.Size, r.reserve, r.inodeReserve); err != nil {
 				return "preallocating space", err
 			}
 			if xattrs, err := hex.DecodeString(sfr.Xattrs); err != nil || len(xattrs) == 0 {
@@ -296,6 +308,7 @@ func (r *Replicator) GetHandler() http.Handler {
 	router := hummingbird.NewRouter()
 	router.Get("/priorityrep", commonHandlers.ThenFunc(r.priorityRepHandler))
 	router.Get("/progress", commonHandlers.ThenFunc(r.ProgressReportHandler))
+	router.Get("/queues", commonHandlers.ThenFunc(r.QueueDepthHandler))
 	for _, policy := range hummingbird.LoadPolicies() {
 		router.HandlePolicy("REPCONN", "/:device/:partition", policy.Index, commonHandlers.ThenFunc(r.objRepConnHandler))
 		router.HandlePolicy("REPLICATE", "/:device/:partition/:suffixes", policy.Index, commonHandlers.ThenFunc(r.objReplicateHandler))
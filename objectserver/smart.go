@@ -0,0 +1,102 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package objectserver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SMART attributes whose raw values should be zero on a healthy drive.
+var smartPreFailAttributes = []string{"Reallocated_Sector_Ct", "Current_Pending_Sector", "Offline_Uncorrectable", "Reported_Uncorrect"}
+
+// smartSource samples SMART attributes for the block device a drive is mounted from.
+type smartSource interface {
+	Attributes(devicePath string) (map[string]int64, error)
+}
+
+// smartctlSource reads attributes with "smartctl -A", which generally requires root.
+type smartctlSource struct {
+	smartctl string
+	mounts   string
+}
+
+func (s *smartctlSource) blockDevice(devicePath string) (string, error) {
+	data, err := ioutil.ReadFile(s.mounts)
+	if err != nil {
+		return "", err
+	}
+	devicePath = filepath.Clean(devicePath)
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[1] == devicePath {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("No mount found for %s", devicePath)
+}
+
+func (s *smartctlSource) Attributes(devicePath string) (map[string]int64, error) {
+	blockDevice, err := s.blockDevice(devicePath)
+	if err != nil {
+		return nil, err
+	}
+	// smartctl uses non-zero exit bits to report drive status, so only give up if there's no output.
+	out, err := exec.Command(s.smartctl, "-A", blockDevice).Output()
+	if len(out) == 0 && err != nil {
+		return nil, err
+	}
+	return parseSmartAttributes(out), nil
+}
+
+// parseSmartAttributes extracts the raw values from smartctl's ATA attribute table.
+func parseSmartAttributes(out []byte) map[string]int64 {
+	attrs := make(map[string]int64)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	inTable := false
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && fields[0] == "ID#" {
+			inTable = true
+			continue
+		}
+		if !inTable || len(fields) < 10 {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[0]); err != nil {
+			continue
+		}
+		if raw, err := strconv.ParseInt(fields[9], 10, 64); err == nil {
+			attrs[fields[1]] = raw
+		}
+	}
+	return attrs
+}
+
+// smartDegraded reports whether the attributes show any pre-failure indicators.
+func smartDegraded(attrs map[string]int64) bool {
+	for _, attr := range smartPreFailAttributes {
+		if attrs[attr] > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,134 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package objectserver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/troubling/hummingbird/hummingbird"
+
+	"github.com/stretchr/testify/require"
+)
+
+var smartctlOutput = []byte(`smartctl 6.5 2016-01-24 r4214 [x86_64-linux-4.4.0-31-generic] (local build)
+Copyright (C) 2002-16, Bruce Allen, Christian Franke, www.smartmontools.org
+
+=== START OF READ SMART DATA SECTION ===
+SMART Attributes Data Structure revision number: 10
+Vendor Specific SMART Attributes with Thresholds:
+ID# ATTRIBUTE_NAME          FLAG     VALUE WORST THRESH TYPE      UPDATED  WHEN_FAILED RAW_VALUE
+  1 Raw_Read_Error_Rate     0x000f   117   099   006    Pre-fail  Always       -       148495896
+  5 Reallocated_Sector_Ct   0x0033   100   100   010    Pre-fail  Always       -       24
+  9 Power_On_Hours          0x0032   086   086   000    Old_age   Always       -       12345
+194 Temperature_Celsius     0x0022   036   045   000    Old_age   Always       -       36 (0 18 0 0 0)
+197 Current_Pending_Sector  0x0012   100   100   000    Old_age   Always       -       0
+198 Offline_Uncorrectable   0x0010   100   100   000    Old_age   Offline      -       0
+`)
+
+func TestParseSmartAttributes(t *testing.T) {
+	attrs := parseSmartAttributes(smartctlOutput)
+	require.Equal(t, int64(24), attrs["Reallocated_Sector_Ct"])
+	require.Equal(t, int64(0), attrs["Current_Pending_Sector"])
+	require.Equal(t, int64(36), attrs["Temperature_Celsius"])
+	require.Equal(t, int64(12345), attrs["Power_On_Hours"])
+	require.Equal(t, 6, len(attrs))
+	require.Equal(t, 0, len(parseSmartAttributes([]byte("garbage"))))
+}
+
+func TestSmartDegraded(t *testing.T) {
+	require.True(t, smartDegraded(parseSmartAttributes(smartctlOutput)))
+	require.False(t, smartDegraded(map[string]int64{"Reallocated_Sector_Ct": 0, "Temperature_Celsius": 40}))
+	require.True(t, smartDegraded(map[string]int64{"Current_Pending_Sector": 8}))
+	require.False(t, smartDegraded(nil))
+}
+
+func TestSmartctlBlockDevice(t *testing.T) {
+	mounts, err := ioutil.TempFile("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(mounts.Name())
+	mounts.WriteString("/dev/sda1 / ext4 rw,relatime 0 0\n/dev/sdb1 /srv/node/sdb xfs rw,noatime 0 0\n")
+	mounts.Close()
+	s := &smartctlSource{smartctl: "smartctl", mounts: mounts.Name()}
+	dev, err := s.blockDevice("/srv/node/sdb/")
+	require.Nil(t, err)
+	require.Equal(t, "/dev/sdb1", dev)
+	_, err = s.blockDevice("/srv/node/sdc")
+	require.NotNil(t, err)
+}
+
+type failingSmartSource struct{}
+
+func (failingSmartSource) Attributes(devicePath string) (map[string]int64, error) {
+	return nil, errors.New("smartctl not found")
+}
+
+func TestCheckSmart(t *testing.T) {
+	replicator, err := newTestReplicator("bind_port", "1234")
+	require.Nil(t, err)
+	check := func(device string) bool {
+		rd := newPatchableReplicationDevice(replicator)
+		rd.dev = &hummingbird.Device{Device: device}
+		rd.checkSmart()
+		for len(replicator.updateStat) > 0 {
+			<-replicator.updateStat
+		}
+		return rd.degraded
+	}
+	replicator.smart = mockSmartSource{
+		"sda": {"Reallocated_Sector_Ct": 0, "Current_Pending_Sector": 0},
+		"sdb": {"Reallocated_Sector_Ct": 0, "Offline_Uncorrectable": 3},
+	}
+	require.False(t, check("sda"))
+	require.True(t, check("sdb"))
+	replicator.smart = failingSmartSource{}
+	require.False(t, check("sdb"))
+}
+
+func TestReplicateSmartDegradedCancel(t *testing.T) {
+	replicator, err := newTestReplicator("bind_port", "1234", "smart_degraded_ms_per_part", "60000")
+	require.Nil(t, err)
+	replicator.smart = mockSmartSource{"sda": {"Reallocated_Sector_Ct": 12}}
+	rd := newPatchableReplicationDevice(replicator)
+	rd.dev = &hummingbird.Device{Device: "sda"}
+	rd._listPartitions = func() ([]string, error) {
+		return []string{"1", "2", "3"}, nil
+	}
+	replicated := make(chan string, 3)
+	rd._replicatePartition = func(partition string) {
+		replicated <- partition
+	}
+	done := make(chan struct{})
+	go func() {
+		for range replicator.updateStat {
+		}
+	}()
+	go func() {
+		rd.Replicate()
+		close(done)
+	}()
+	<-replicated
+	rd.Cancel()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Replicate didn't return after Cancel")
+	}
+	require.Equal(t, 0, len(replicated))
+}
@@ -39,6 +39,7 @@ type SwiftObject struct {
 	workingClass string
 	metadata     map[string]string
 	reserve      int64
+	inodeReserve int64
 	reclaimAge   int64
 }
 
@@ -70,6 +71,11 @@ func (o *SwiftObject) Exists() bool {
 	return strings.HasSuffix(o.dataFile, ".data")
 }
 
+// Deleted returns true if the object's newest file is a tombstone.
+func (o *SwiftObject) Deleted() bool {
+	return strings.HasSuffix(o.dataFile, ".ts")
+}
+
 // Copy copies all data from the underlying .data file to the given writers.
 func (o *SwiftObject) Copy(dsts ...io.Writer) (written int64, err error) {
 	if len(dsts) == 1 {
@@ -103,7 +109,7 @@ func (o *SwiftObject) newFile(class string, size int64) (io.Writer, error) {
 	if o.afw, err = NewAtomicFileWriter(o.tempDir, o.hashDir); err != nil {
 		return nil, fmt.Errorf("Error creating temp file: %v", err)
 	}
-	if err := o.afw.Preallocate(size, o.reserve); err != nil {
+	if err := o.afw.Preallocate(size, o.reserve, o.inodeReserve); err != nil {
 		o.afw.Abandon()
 		return nil, DriveFullError
 	}
@@ -139,6 +145,16 @@ func (o *SwiftObject) Commit(metadata map[string]string) error {
 	return nil
 }
 
+// CommitMeta writes the metadata to a new .meta file, which is layered over the .data file's metadata when read.
+func (o *SwiftObject) CommitMeta(metadata map[string]string) error {
+	if _, err := o.newFile("meta", 0); err != nil {
+		return err
+	} else {
+		defer o.Close()
+		return o.Commit(metadata)
+	}
+}
+
 // Delete deletes the object.
 func (o *SwiftObject) Delete(metadata map[string]string) error {
 	if _, err := o.newFile("ts", 0); err != nil {
@@ -167,6 +183,7 @@ type SwiftObjectFactory struct {
 	hashPathPrefix string
 	hashPathSuffix string
 	reserve        int64
+	inodeReserve   int64
 	reclaimAge     int64
 	policy         int
 }
@@ -174,7 +191,7 @@ type SwiftObjectFactory struct {
 // New returns an instance of SwiftObject with the given parameters. Metadata is read in and if needData is true, the file is opened.
 func (f *SwiftObjectFactory) New(vars map[string]string, needData bool) (Object, error) {
 	var err error
-	sor := &SwiftObject{reclaimAge: f.reclaimAge, reserve: f.reserve}
+	sor := &SwiftObject{reclaimAge: f.reclaimAge, reserve: f.reserve, inodeReserve: f.inodeReserve}
 	sor.hashDir = ObjHashDir(vars, f.driveRoot, f.hashPathPrefix, f.hashPathSuffix, f.policy)
 	sor.tempDir = TempDirPath(f.driveRoot, vars["device"])
 	sor.dataFile, sor.metaFile = ObjectFiles(sor.hashDir)
@@ -219,6 +236,7 @@ var replicationDone = fmt.Errorf("Replication done")
 func SwiftEngineConstructor(config hummingbird.Config, policy *hummingbird.Policy, flags *flag.FlagSet) (ObjectEngine, error) {
 	driveRoot := config.GetDefault("app:object-server", "devices", "/srv/node")
 	reserve := config.GetInt("app:object-server", "fallocate_reserve", 0)
+	inodeReserve := config.GetInt("app:object-server", "inode_reserve", 0)
 	hashPathPrefix, hashPathSuffix, err := hummingbird.GetHashPrefixAndSuffix()
 	if err != nil {
 		return nil, errors.New("Unable to load hashpath prefix and suffix")
@@ -229,6 +247,7 @@ func SwiftEngineConstructor(config hummingbird.Config, policy *hummingbird.Polic
 		hashPathPrefix: hashPathPrefix,
 		hashPathSuffix: hashPathSuffix,
 		reserve:        reserve,
+		inodeReserve:   inodeReserve,
 		reclaimAge:     reclaimAge,
 		policy:         policy.Index}, nil
 }
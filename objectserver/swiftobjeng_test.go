@@ -94,6 +94,47 @@ func TestSwiftObjectQuarantine(t *testing.T) {
 	require.True(t, hummingbird.Exists(filepath.Join(driveRoot, "sda", "quarantined")))
 }
 
+func TestSwiftObjectQuarantineInconsistentTimestamps(t *testing.T) {
+	for _, test := range []struct {
+		dataTimestamp string
+		metaTimestamp string
+	}{
+		{"1234567890.12345", "1234567889.00000"},
+		{"1234567890.12345", ""},
+		{"bogus", "1234567891.00000"},
+		{"bogus", "none"},
+	} {
+		driveRoot, err := ioutil.TempDir("", "")
+		require.Nil(t, err)
+		defer os.RemoveAll(driveRoot)
+
+		vars := map[string]string{"device": "sda", "account": "a", "container": "c", "object": "o", "partition": "3"}
+		swcon := &SwiftObjectFactory{driveRoot: driveRoot, hashPathPrefix: "prefix", hashPathSuffix: "suffix"}
+		swo, err := swcon.New(vars, false)
+		require.Nil(t, err)
+		w, err := swo.SetData(1)
+		require.Nil(t, err)
+		w.Write([]byte("!"))
+		require.Nil(t, swo.Commit(map[string]string{"Content-Length": "1", "Content-Type": "text/plain", "X-Timestamp": test.dataTimestamp}))
+		swo.Close()
+		if test.metaTimestamp != "none" {
+			hashDir := ObjHashDir(vars, driveRoot, "prefix", "suffix", 0)
+			metaMetadata := map[string]string{"name": "/a/c/o"}
+			if test.metaTimestamp != "" {
+				metaMetadata["X-Timestamp"] = test.metaTimestamp
+			}
+			f, err := os.Create(filepath.Join(hashDir, "1234567899.00000.meta"))
+			require.Nil(t, err)
+			require.Nil(t, WriteMetadata(f.Fd(), metaMetadata))
+			f.Close()
+		}
+
+		_, err = swcon.New(vars, true)
+		require.NotNil(t, err)
+		require.True(t, hummingbird.Exists(filepath.Join(driveRoot, "sda", "quarantined", "objects")))
+	}
+}
+
 func TestSwiftObjectMultiCopy(t *testing.T) {
 	driveRoot, err := ioutil.TempDir("", "")
 	require.Nil(t, err)
@@ -55,7 +55,11 @@ func (o *TempFile) Save(dst string) error {
 }
 
 // Preallocate pre-allocates space for the file.
-func (o *TempFile) Preallocate(size int64, reserve int64) error {
+func (o *TempFile) Preallocate(size int64, reserve int64, inodeReserve int64) error {
+	return preallocate(o.File, size, reserve, inodeReserve)
+}
+
+func preallocate(f *os.File, size int64, reserve int64, inodeReserve int64) error {
 	// TODO: this could be done for most non-linux operating systems, but it hasn't been important.
 	return nil
 }
@@ -42,6 +42,7 @@ var (
 	AT_SYMLINK_FOLLOW = C.AT_SYMLINK_FOLLOW
 	O_TMPFILE         = C.__O_TMPFILE | syscall.O_DIRECTORY
 	useOTempfile      = false
+	fstatfs           = syscall.Fstatfs
 )
 
 func init() {
@@ -130,18 +131,26 @@ func (o *TempFile) Save(dst string) error {
 }
 
 // Preallocate pre-allocates space for the file.
-func (o *TempFile) Preallocate(size int64, reserve int64) error {
+func (o *TempFile) Preallocate(size int64, reserve int64, inodeReserve int64) error {
+	return preallocate(o.File, size, reserve, inodeReserve)
+}
+
+// preallocate pre-allocates space for f, failing if that would leave less than reserve bytes or inodeReserve inodes free.
+func preallocate(f *os.File, size int64, reserve int64, inodeReserve int64) error {
 	var st syscall.Statfs_t
-	if reserve > 0 {
-		if err := syscall.Fstatfs(int(o.Fd()), &st); err == nil {
+	if reserve > 0 || inodeReserve > 0 {
+		if err := fstatfs(int(f.Fd()), &st); err == nil {
 			freeSpace := int64(st.Frsize) * int64(st.Bavail)
-			if freeSpace-size < reserve {
+			if reserve > 0 && freeSpace-size < reserve {
 				return errors.New("Not enough reserve space on disk.")
 			}
+			if inodeReserve > 0 && int64(st.Ffree) < inodeReserve {
+				return errors.New("Not enough reserve inodes on disk.")
+			}
 		}
 	}
 	if size > 0 {
-		syscall.Fallocate(int(o.Fd()), 1, 0, size)
+		syscall.Fallocate(int(f.Fd()), 1, 0, size)
 	}
 	return nil
 }
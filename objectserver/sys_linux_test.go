@@ -0,0 +1,100 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+// +build linux
+
+package objectserver
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/troubling/hummingbird/hummingbird"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mockFstatfs(freeInodes uint64) func() {
+	oldFstatfs := fstatfs
+	fstatfs = func(fd int, st *syscall.Statfs_t) error {
+		st.Frsize = 4096
+		st.Bavail = 1 << 30
+		st.Ffree = freeInodes
+		return nil
+	}
+	return func() { fstatfs = oldFstatfs }
+}
+
+func TestPreallocateInodeReserve(t *testing.T) {
+	defer mockFstatfs(5)()
+	dir, err := ioutil.TempDir("", "")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	f, err := NewAtomicFileWriter(dir, dir)
+	require.Nil(t, err)
+	defer f.Abandon()
+	require.NotNil(t, f.Preallocate(0, 0, 10))
+	require.Nil(t, f.Preallocate(0, 0, 5))
+	require.Nil(t, f.Preallocate(0, 0, 0))
+}
+
+func TestPutInodeReserve(t *testing.T) {
+	defer mockFstatfs(5)()
+	ts, err := makeObjectServer("inode_reserve", "10")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME DATA")))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", "9")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	assert.Equal(t, 507, resp.StatusCode)
+
+	req, err = http.NewRequest("DELETE", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), nil)
+	require.Nil(t, err)
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	resp, err = http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	assert.Equal(t, 507, resp.StatusCode)
+}
+
+func TestStageUploadInodeReserve(t *testing.T) {
+	defer mockFstatfs(5)()
+	ts, err := makeObjectServer("resumable_uploads", "true", "inode_reserve", "10")
+	require.Nil(t, err)
+	defer ts.Close()
+
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s:%d/sda/0/a/c/o", ts.host, ts.port), bytes.NewBuffer([]byte("SOME")))
+	require.Nil(t, err)
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
+	req.Header.Set("X-Upload-Token", "abc")
+	req.Header.Set("X-Upload-Length", "10")
+	req.Header.Set("X-Upload-Offset", "0")
+	resp, err := http.DefaultClient.Do(req)
+	require.Nil(t, err)
+	assert.Equal(t, 507, resp.StatusCode)
+	uploads, _ := hummingbird.ReadDirNames(uploadsDir(ts.root, "sda"))
+	assert.Equal(t, 0, len(uploads))
+}
@@ -126,31 +126,40 @@ func (server *ObjectServer) updateContainer(metadata map[string]string, request
 		requestHeaders.Add("X-Size", metadata["Content-Length"])
 		requestHeaders.Add("X-Etag", metadata["ETag"])
 	}
+	// a POST rewrites the object's listing entry.
+	method := request.Method
+	if method == "POST" {
+		method = "PUT"
+	}
 	failures := 0
 	for index := range hosts {
-		if !server.sendContainerUpdate(hosts[index], devices[index], request.Method, partition, vars["account"], vars["container"], vars["obj"], requestHeaders) {
+		if !server.sendContainerUpdate(hosts[index], devices[index], method, partition, vars["account"], vars["container"], vars["obj"], requestHeaders) {
 			logger.LogError("ERROR container update failed with %s/%s (saving for async update later)", hosts[index], devices[index])
 			failures++
 		}
 	}
 	if failures > 0 {
-		server.saveAsync(request.Method, vars["account"], vars["container"], vars["obj"], vars["device"], requestHeaders)
+		server.saveAsync(method, vars["account"], vars["container"], vars["obj"], vars["device"], requestHeaders)
 	}
 }
 
-func (server *ObjectServer) updateDeleteAt(request *http.Request, deleteAtStr string, vars map[string]string, logger hummingbird.LoggingContext) {
+// updateDeleteAt sends method for the object's entry under deleteAtStr in the expiring objects account.
+func (server *ObjectServer) updateDeleteAt(method string, request *http.Request, deleteAtStr string, vars map[string]string, logger hummingbird.LoggingContext) {
 	deleteAt, err := hummingbird.ParseDate(deleteAtStr)
 	if err != nil {
 		return
 	}
-	container := hummingbird.GetDefault(request.Header, "X-Delete-At-Container", "")
-	if container == "" {
-		container = server.expirerContainer(deleteAt, vars["account"], vars["container"], vars["obj"])
+	container := server.expirerContainer(deleteAt, vars["account"], vars["container"], vars["obj"])
+	partition := ""
+	var hosts, devices []string
+	// the X-Delete-At-* headers locate the entry the request itself is making, not one it's replacing.
+	if method == request.Method || deleteAtStr == request.Header.Get("X-Delete-At") {
+		container = hummingbird.GetDefault(request.Header, "X-Delete-At-Container", container)
+		partition = hummingbird.GetDefault(request.Header, "X-Delete-At-Partition", "")
+		hosts = splitHeader(request.Header.Get("X-Delete-At-Host"))
+		devices = splitHeader(request.Header.Get("X-Delete-At-Device"))
 	}
 	obj := fmt.Sprintf("%010d-%s/%s/%s", deleteAt.Unix(), vars["account"], vars["container"], vars["obj"])
-	partition := hummingbird.GetDefault(request.Header, "X-Delete-At-Partition", "")
-	hosts := splitHeader(request.Header.Get("X-Delete-At-Host"))
-	devices := splitHeader(request.Header.Get("X-Delete-At-Device"))
 	requestHeaders := http.Header{
 		"X-Backend-Storage-Policy-Index": {hummingbird.GetDefault(request.Header, "X-Backend-Storage-Policy-Index", "0")},
 		"Referer":                        {hummingbird.GetDefault(request.Header, "Referer", "-")},
@@ -158,28 +167,40 @@ func (server *ObjectServer) updateDeleteAt(request *http.Request, deleteAtStr st
 		"X-Trans-Id":                     {hummingbird.GetDefault(request.Header, "X-Trans-Id", "-")},
 		"X-Timestamp":                    {request.Header.Get("X-Timestamp")},
 	}
-	if request.Method != "DELETE" {
+	if method != "DELETE" {
 		requestHeaders.Add("X-Content-Type", "text/plain")
 		requestHeaders.Add("X-Size", "0")
 		requestHeaders.Add("X-Etag", zeroByteHash)
 	}
 	failures := 0
 	for index := range hosts {
-		if !server.sendContainerUpdate(hosts[index], devices[index], request.Method, partition, deleteAtAccount, container, obj, requestHeaders) {
+		if !server.sendContainerUpdate(hosts[index], devices[index], method, partition, deleteAtAccount, container, obj, requestHeaders) {
 			logger.LogError("ERROR container update failed with %s/%s (saving for async update later)", hosts[index], devices[index])
 			failures++
 		}
 	}
 	if failures > 0 || len(hosts) == 0 {
-		server.saveAsync(request.Method, deleteAtAccount, container, obj, vars["device"], requestHeaders)
+		server.saveAsync(method, deleteAtAccount, container, obj, vars["device"], requestHeaders)
 	}
 }
 
-func (server *ObjectServer) containerUpdates(request *http.Request, metadata map[string]string, deleteAt string, vars map[string]string, logger hummingbird.LoggingContext) {
-	defer logger.LogPanics("PANIC WHILE UPDATING CONTAINER LISTINGS")
+// deleteAtUpdates queues the object with the expirer under deleteAt, and takes it out from under oldDeleteAt if that's changed.
+func (server *ObjectServer) deleteAtUpdates(request *http.Request, deleteAt string, oldDeleteAt string, vars map[string]string, logger hummingbird.LoggingContext) {
 	if deleteAt != "" {
-		go server.updateDeleteAt(request, deleteAt, vars, logger)
+		method := request.Method
+		if method == "POST" {
+			method = "PUT"
+		}
+		go server.updateDeleteAt(method, request, deleteAt, vars, logger)
 	}
+	if oldDeleteAt != "" && oldDeleteAt != deleteAt {
+		go server.updateDeleteAt("DELETE", request, oldDeleteAt, vars, logger)
+	}
+}
+
+func (server *ObjectServer) containerUpdates(request *http.Request, metadata map[string]string, deleteAt string, oldDeleteAt string, vars map[string]string, logger hummingbird.LoggingContext) {
+	defer logger.LogPanics("PANIC WHILE UPDATING CONTAINER LISTINGS")
+	server.deleteAtUpdates(request, deleteAt, oldDeleteAt, vars, logger)
 
 	firstDone := make(chan struct{}, 1)
 	go func() {
@@ -92,11 +92,11 @@ func TestUpdateDeleteAt(t *testing.T) {
 	vars := map[string]string{"account": "a", "container": "c", "obj": "o", "device": "sda"}
 	req = hummingbird.SetVars(req, vars)
 	deleteAtStr := "1434707411"
-	server.updateDeleteAt(req, deleteAtStr, vars, &dl)
+	server.updateDeleteAt("PUT", req, deleteAtStr, vars, &dl)
 	require.True(t, requestSent)
 
 	cs.Close()
-	server.updateDeleteAt(req, deleteAtStr, vars, &dl)
+	server.updateDeleteAt("PUT", req, deleteAtStr, vars, &dl)
 	expectedFile := filepath.Join(ts.root, "sda", "async_pending", "8fc", "02cc012fe572f27e455edbea32da78fc-12345.6789")
 	require.True(t, hummingbird.Exists(expectedFile))
 	data, err := ioutil.ReadFile(expectedFile)
@@ -124,7 +124,7 @@ func TestUpdateDeleteAtNoHeaders(t *testing.T) {
 	vars := map[string]string{"account": "a", "container": "c", "obj": "o", "device": "sda"}
 	req = hummingbird.SetVars(req, vars)
 	deleteAtStr := "1434707411"
-	server.updateDeleteAt(req, deleteAtStr, vars, &DummyLogger{})
+	server.updateDeleteAt("PUT", req, deleteAtStr, vars, &DummyLogger{})
 	expectedFile := filepath.Join(ts.root, "sda", "async_pending", "8fc", "02cc012fe572f27e455edbea32da78fc-12345.6789")
 	require.True(t, hummingbird.Exists(expectedFile))
 	data, err := ioutil.ReadFile(expectedFile)
@@ -0,0 +1,180 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package objectserver
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/troubling/hummingbird/hummingbird"
+)
+
+// uploadCleanupInterval is how often the server looks for abandoned uploads.
+var uploadCleanupInterval = 10 * time.Minute
+
+// stagedUpload is the body of a resumable PUT, received so far over one or more requests sharing an X-Upload-Token.
+type stagedUpload struct {
+	dir  string
+	lock *os.File
+	file *os.File
+	size int64
+}
+
+func uploadsDir(driveRoot, device string) string {
+	return filepath.Join(driveRoot, device, "uploads")
+}
+
+// close releases the upload, leaving its data staged for the next request.
+func (u *stagedUpload) close() {
+	u.file.Close()
+	u.lock.Close()
+}
+
+// remove deletes the upload's staged data once the object has been committed.  Until then, a request that fails can
+// be retried without resending anything.  The upload still needs to be closed.
+func (u *stagedUpload) remove() {
+	os.RemoveAll(u.dir)
+}
+
+// stageUpload appends body to the upload named by the request's X-Upload-Token.  The request's X-Upload-Offset must
+// match the bytes already staged and X-Upload-Length gives the size of the whole object.  Once every byte has
+// arrived, the upload is returned ready to read from the start; otherwise status is the response to send, and
+// offset the number of bytes staged.
+func (server *ObjectServer) stageUpload(request *http.Request, vars map[string]string, body io.Reader, maxObjectSize int64) (upload *stagedUpload, status int, offset int64) {
+	total, err := strconv.ParseInt(request.Header.Get("X-Upload-Length"), 10, 64)
+	if err != nil || total < 0 {
+		return nil, http.StatusBadRequest, 0
+	}
+	if maxObjectSize > 0 && total > maxObjectSize {
+		return nil, http.StatusRequestEntityTooLarge, 0
+	}
+	if offset, err = strconv.ParseInt(request.Header.Get("X-Upload-Offset"), 10, 64); err != nil || offset < 0 {
+		return nil, http.StatusBadRequest, 0
+	}
+	id := md5.Sum([]byte("/" + vars["account"] + "/" + vars["container"] + "/" + vars["obj"] + "/" + request.Header.Get("X-Upload-Token")))
+	upload = &stagedUpload{dir: filepath.Join(uploadsDir(server.driveRoot, vars["device"]), hex.EncodeToString(id[:]))}
+	if upload.lock, err = hummingbird.LockPath(upload.dir, 10*time.Second); err != nil {
+		hummingbird.GetLogger(request).LogError("Error locking upload %s: %v", upload.dir, err)
+		return nil, http.StatusConflict, 0
+	}
+	if upload.file, err = os.OpenFile(filepath.Join(upload.dir, "data"), os.O_RDWR|os.O_CREATE, 0600); err != nil {
+		hummingbird.GetLogger(request).LogError("Error opening upload %s: %v", upload.dir, err)
+		upload.lock.Close()
+		return nil, http.StatusInternalServerError, 0
+	}
+	if upload.size, err = upload.file.Seek(0, os.SEEK_END); err != nil {
+		hummingbird.GetLogger(request).LogError("Error reading upload %s: %v", upload.dir, err)
+		upload.close()
+		return nil, http.StatusInternalServerError, 0
+	}
+	if offset != upload.size {
+		upload.close()
+		return nil, http.StatusConflict, upload.size
+	}
+	if upload.size == 0 {
+		if err := preallocate(upload.file, total, server.reserve, server.inodeReserve); err != nil {
+			hummingbird.GetLogger(request).LogDebug("Not enough space available for upload %s: %v", upload.dir, err)
+			upload.remove()
+			upload.close()
+			return nil, 507, 0
+		}
+	}
+	// read at most one byte past the end, so a body that overruns X-Upload-Length is noticed.
+	n, err := hummingbird.Copy(io.LimitReader(body, total-upload.size+1), upload.file)
+	if upload.size+n > total {
+		upload.file.Truncate(upload.size)
+		upload.close()
+		return nil, http.StatusRequestEntityTooLarge, offset
+	}
+	upload.size += n
+	if syncErr := upload.file.Sync(); syncErr != nil && err == nil {
+		err = syncErr
+	}
+	if err == io.ErrUnexpectedEOF {
+		upload.close()
+		return nil, 499, upload.size
+	} else if err == errSlowUpload {
+		hummingbird.GetLogger(request).LogError("Pausing upload %s: %v", request.URL.Path, err)
+		upload.close()
+		return nil, http.StatusRequestTimeout, upload.size
+	} else if err != nil {
+		hummingbird.GetLogger(request).LogError("Error writing to upload %s: %v", upload.dir, err)
+		upload.close()
+		return nil, http.StatusInternalServerError, upload.size
+	}
+	if upload.size < total {
+		upload.close()
+		return nil, http.StatusAccepted, upload.size
+	}
+	if _, err := upload.file.Seek(0, os.SEEK_SET); err != nil {
+		upload.close()
+		return nil, http.StatusInternalServerError, upload.size
+	}
+	return upload, 0, upload.size
+}
+
+// removeAbandonedUploads deletes uploads in dir that haven't been written to in maxAge, skipping any still in use.
+func removeAbandonedUploads(dir string, maxAge time.Duration) int {
+	ids, err := hummingbird.ReadDirNames(dir)
+	if err != nil {
+		return 0
+	}
+	removed := 0
+	for _, id := range ids {
+		uploadDir := filepath.Join(dir, id)
+		info, err := os.Stat(filepath.Join(uploadDir, "data"))
+		if err != nil {
+			info, err = os.Stat(uploadDir)
+		}
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		lock, err := hummingbird.LockPath(uploadDir, time.Millisecond)
+		if err != nil {
+			continue
+		}
+		os.RemoveAll(uploadDir)
+		lock.Close()
+		removed++
+	}
+	return removed
+}
+
+func (server *ObjectServer) cleanupUploads() {
+	for {
+		select {
+		case <-server.stop:
+			return
+		case <-time.After(uploadCleanupInterval):
+		}
+		devices, err := hummingbird.ReadDirNames(server.driveRoot)
+		if err != nil {
+			continue
+		}
+		for _, device := range devices {
+			if removed := removeAbandonedUploads(uploadsDir(server.driveRoot, device), server.uploadTimeout); removed > 0 {
+				server.logger.Info(fmt.Sprintf("Removed %d abandoned uploads from %s", removed, device))
+			}
+		}
+	}
+}
@@ -41,9 +41,7 @@ func (server *ProxyServer) AccountGetHandler(writer http.ResponseWriter, request
 		"delimiter":  request.FormValue("delimiter"),
 	}
 	r, headers, code := server.C.GetAccount(vars["account"], options, request.Header)
-	for k := range headers {
-		writer.Header().Set(k, headers.Get(k))
-	}
+	copyResponseHeaders(writer.Header(), headers)
 	writer.WriteHeader(code)
 	if r != nil {
 		defer r.Close()
@@ -63,9 +61,7 @@ func (server *ProxyServer) AccountHeadHandler(writer http.ResponseWriter, reques
 		return
 	}
 	headers, code := server.C.HeadAccount(vars["account"], request.Header)
-	for k := range headers {
-		writer.Header().Set(k, headers.Get(k))
-	}
+	copyResponseHeaders(writer.Header(), headers)
 	writer.WriteHeader(code)
 }
 
@@ -0,0 +1,181 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxyserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/hummingbird"
+)
+
+// archiveListingLimit is how many objects are listed per container GET while building an archive.
+var archiveListingLimit = 1000
+
+type archiveWriter interface {
+	add(name string, size int64, modified time.Time) (io.Writer, error)
+	Close() error
+}
+
+type tarArchive struct {
+	*tar.Writer
+}
+
+func (t *tarArchive) add(name string, size int64, modified time.Time) (io.Writer, error) {
+	if err := t.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: size, ModTime: modified, Typeflag: tar.TypeReg}); err != nil {
+		return nil, err
+	}
+	return t.Writer, nil
+}
+
+type zipArchive struct {
+	*zip.Writer
+}
+
+func (z *zipArchive) add(name string, size int64, modified time.Time) (io.Writer, error) {
+	hdr := &zip.FileHeader{Name: name, Method: zip.Store}
+	hdr.SetModTime(modified)
+	return z.CreateHeader(hdr)
+}
+
+// archiveEntryName returns the name to store obj under in an archive, or false if it can't be extracted safely.
+func archiveEntryName(obj string) (string, bool) {
+	name := strings.TrimLeft(obj, "/")
+	if name == "" {
+		return "", false
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// listContainer returns a page of the container listing, or a status code if the listing failed.
+func (server *ProxyServer) listContainer(account, container, prefix, marker string, headers http.Header) ([]client.ObjectRecord, int) {
+	options := map[string]string{
+		"format": "json",
+		"limit":  strconv.Itoa(archiveListingLimit),
+		"prefix": prefix,
+		"marker": marker,
+	}
+	r, _, code := server.C.GetContainer(account, container, options, headers)
+	if r != nil {
+		defer r.Close()
+	}
+	if code/100 != 2 {
+		return nil, code
+	}
+	var records []client.ObjectRecord
+	if code != 204 && r != nil {
+		if err := json.NewDecoder(r).Decode(&records); err != nil {
+			return nil, 500
+		}
+	}
+	return records, code
+}
+
+// containerArchive streams the container's objects (optionally limited to a prefix) to the client as a tar or zip file.
+// Objects are fetched one at a time as the listing is paged through, so memory use doesn't depend on the number of objects.
+func (server *ProxyServer) containerArchive(writer http.ResponseWriter, request *http.Request, format string) {
+	vars := hummingbird.GetVars(request)
+	prefix := request.FormValue("prefix")
+	if format != "tar" && format != "zip" {
+		http.Error(writer, fmt.Sprintf("Unsupported archive format: %s", format), http.StatusBadRequest)
+		return
+	}
+	listHeaders := http.Header{"X-Trans-Id": []string{request.Header.Get("X-Trans-Id")}}
+	records, code := server.listContainer(vars["account"], vars["container"], prefix, "", listHeaders)
+	if code/100 != 2 {
+		hummingbird.StandardResponse(writer, code)
+		return
+	}
+
+	var archive archiveWriter
+	if format == "tar" {
+		writer.Header().Set("Content-Type", "application/x-tar")
+		archive = &tarArchive{tar.NewWriter(writer)}
+	} else {
+		writer.Header().Set("Content-Type", "application/zip")
+		archive = &zipArchive{zip.NewWriter(writer)}
+	}
+	writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", vars["container"], format))
+	writer.WriteHeader(http.StatusOK)
+
+	// once the 200 is sent, a failure can only be reported by cutting the archive off before its trailer, so the
+	// client can't mistake it for a complete one.
+	for len(records) > 0 {
+		for _, record := range records {
+			name, ok := archiveEntryName(record.Name)
+			if !ok {
+				hummingbird.GetLogger(request).LogInfo("Leaving %s/%s/%s out of archive: unsafe name", vars["account"], vars["container"], record.Name)
+				continue
+			}
+			if err := server.archiveObject(archive, vars["account"], vars["container"], record.Name, name, listHeaders); err != nil {
+				hummingbird.GetLogger(request).LogError("Error archiving %s/%s/%s: %v", vars["account"], vars["container"], record.Name, err)
+				panic(http.ErrAbortHandler)
+			}
+		}
+		marker := records[len(records)-1].Name
+		if records, code = server.listContainer(vars["account"], vars["container"], prefix, marker, listHeaders); code/100 != 2 {
+			hummingbird.GetLogger(request).LogError("Error listing %s/%s after %s: %d", vars["account"], vars["container"], marker, code)
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		hummingbird.GetLogger(request).LogError("Error finishing archive of %s/%s: %v", vars["account"], vars["container"], err)
+	}
+}
+
+// archiveObject copies a single object into the archive as name.  Objects that have disappeared since they were listed are skipped.
+func (server *ProxyServer) archiveObject(archive archiveWriter, account, container, obj, name string, headers http.Header) error {
+	r, objHeaders, code := server.C.GetObject(account, container, obj, headers)
+	if r != nil {
+		defer r.Close()
+	}
+	if code == 404 {
+		return nil
+	} else if code/100 != 2 || r == nil {
+		return fmt.Errorf("GET returned %d", code)
+	}
+	size, err := strconv.ParseInt(objHeaders.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Invalid Content-Length: %q", objHeaders.Get("Content-Length"))
+	}
+	modified, err := hummingbird.ParseDate(objHeaders.Get("Last-Modified"))
+	if err != nil {
+		modified = time.Now()
+	}
+	w, err := archive.add(name, size, modified)
+	if err != nil {
+		return err
+	}
+	if copied, err := hummingbird.Copy(r, w); err != nil {
+		return err
+	} else if copied != size {
+		return fmt.Errorf("Read %d of %d bytes", copied, size)
+	}
+	return nil
+}
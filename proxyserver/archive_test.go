@@ -0,0 +1,212 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxyserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/hummingbird"
+
+	"github.com/stretchr/testify/require"
+)
+
+// archiveClient serves a single container's objects from memory.
+type archiveClient struct {
+	client.ProxyClient
+	objects     map[string]string
+	broken      map[string]bool
+	listings    int
+	failListing int
+}
+
+func (c *archiveClient) GetContainer(account string, container string, options map[string]string, headers http.Header) (io.ReadCloser, http.Header, int) {
+	c.listings++
+	if c.listings == c.failListing {
+		return nil, nil, 503
+	}
+	limit, _ := strconv.Atoi(options["limit"])
+	names := []string{}
+	for name := range c.objects {
+		if strings.HasPrefix(name, options["prefix"]) && name > options["marker"] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > limit {
+		names = names[:limit]
+	}
+	records := []client.ObjectRecord{}
+	for _, name := range names {
+		records = append(records, client.ObjectRecord{Name: name, Bytes: len(c.objects[name])})
+	}
+	data, _ := json.Marshal(records)
+	return ioutil.NopCloser(bytes.NewBuffer(data)), http.Header{}, 200
+}
+
+func (c *archiveClient) GetObject(account string, container string, obj string, headers http.Header) (io.ReadCloser, http.Header, int) {
+	body, ok := c.objects[obj]
+	if !ok {
+		return nil, nil, 404
+	} else if c.broken[obj] {
+		return nil, nil, 500
+	}
+	h := http.Header{}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	h.Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+	return ioutil.NopCloser(bytes.NewBufferString(body)), h, 200
+}
+
+func archiveRequest(t *testing.T, c *archiveClient, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	serveArchive(t, c, query, w)
+	return w
+}
+
+func serveArchive(t *testing.T, c *archiveClient, query string, w *httptest.ResponseRecorder) {
+	server := &ProxyServer{C: c, logger: &SysLogMock{}}
+	request, err := http.NewRequest("GET", "/v1/a/c?"+query, nil)
+	require.Nil(t, err)
+	request = hummingbird.SetVars(request, map[string]string{"account": "a", "container": "c"})
+	request = hummingbird.SetLogger(request, &hummingbird.RequestLogger{Request: request, Logger: server.logger})
+	ctx := &ProxyContext{
+		ProxyContextMiddleware: &ProxyContextMiddleware{c: c},
+		accountInfoCache:       map[string]*AccountInfo{"account/a": {}},
+		containerInfoCache:     map[string]*ContainerInfo{"container/c": {}},
+	}
+	request = request.WithContext(context.WithValue(request.Context(), "proxycontext", ctx))
+	server.ContainerGetHandler(w, request)
+}
+
+func TestContainerArchiveTar(t *testing.T) {
+	defer func(limit int) { archiveListingLimit = limit }(archiveListingLimit)
+	archiveListingLimit = 2
+	c := &archiveClient{objects: map[string]string{
+		"photos/1.jpg": "first",
+		"photos/2.jpg": "second photo",
+		"photos/3.jpg": "",
+		"docs/a.txt":   "not included",
+	}}
+	w := archiveRequest(t, c, "archive=tar&prefix=photos/")
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "application/x-tar", w.Header().Get("Content-Type"))
+	require.Equal(t, 3, c.listings)
+
+	contents := map[string]string{}
+	names := []string{}
+	tr := tar.NewReader(w.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		data, err := ioutil.ReadAll(tr)
+		require.Nil(t, err)
+		names = append(names, hdr.Name)
+		contents[hdr.Name] = string(data)
+	}
+	require.Equal(t, []string{"photos/1.jpg", "photos/2.jpg", "photos/3.jpg"}, names)
+	require.Equal(t, "first", contents["photos/1.jpg"])
+	require.Equal(t, "second photo", contents["photos/2.jpg"])
+	require.Equal(t, "", contents["photos/3.jpg"])
+}
+
+func TestContainerArchiveZip(t *testing.T) {
+	c := &archiveClient{objects: map[string]string{"a": "AAA", "b": "BBBB"}}
+	w := archiveRequest(t, c, "archive=zip")
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(zr.File))
+	for i, expected := range []string{"AAA", "BBBB"} {
+		f, err := zr.File[i].Open()
+		require.Nil(t, err)
+		data, err := ioutil.ReadAll(f)
+		require.Nil(t, err)
+		require.Equal(t, expected, string(data))
+	}
+}
+
+func TestContainerArchiveBadFormat(t *testing.T) {
+	w := archiveRequest(t, &archiveClient{}, "archive=rar")
+	require.Equal(t, 400, w.Code)
+}
+
+// abortedArchiveRequest runs an archive request that's expected to fail part way, returning what was written before it did.
+func abortedArchiveRequest(t *testing.T, c *archiveClient, query string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	func() {
+		defer func() {
+			require.Equal(t, http.ErrAbortHandler, recover())
+		}()
+		serveArchive(t, c, query, w)
+	}()
+	return w
+}
+
+func TestContainerArchiveAbortsOnObjectError(t *testing.T) {
+	c := &archiveClient{objects: map[string]string{"a": "AAA", "b": "BBBB", "c": "C"}, broken: map[string]bool{"b": true}}
+	w := abortedArchiveRequest(t, c, "archive=tar")
+	require.Equal(t, 200, w.Code)
+	// just the header and data for "a", with no end-of-archive trailer.
+	require.Equal(t, 512+3, w.Body.Len())
+}
+
+func TestContainerArchiveAbortsOnListingError(t *testing.T) {
+	defer func(limit int) { archiveListingLimit = limit }(archiveListingLimit)
+	archiveListingLimit = 1
+	c := &archiveClient{objects: map[string]string{"a": "AAA", "b": "BBBB"}, failListing: 2}
+	w := abortedArchiveRequest(t, c, "archive=zip")
+	require.Equal(t, 200, w.Code)
+	_, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NotNil(t, err)
+}
+
+func TestContainerArchiveUnsafeNames(t *testing.T) {
+	c := &archiveClient{objects: map[string]string{
+		"/abs/x":    "absolute",
+		"../evil":   "escapes",
+		"a/../../b": "escapes too",
+		"ok..":      "fine",
+	}}
+	w := archiveRequest(t, c, "archive=tar")
+	require.Equal(t, 200, w.Code)
+	names := []string{}
+	tr := tar.NewReader(w.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		names = append(names, hdr.Name)
+	}
+	require.Equal(t, []string{"abs/x", "ok.."}, names)
+}
@@ -36,6 +36,10 @@ func (server *ProxyServer) ContainerGetHandler(writer http.ResponseWriter, reque
 		hummingbird.StandardResponse(writer, 401)
 		return
 	}
+	if format := request.FormValue("archive"); format != "" {
+		server.containerArchive(writer, request, format)
+		return
+	}
 	options := map[string]string{
 		"format":     request.FormValue("format"),
 		"limit":      request.FormValue("limit"),
@@ -45,9 +49,7 @@ func (server *ProxyServer) ContainerGetHandler(writer http.ResponseWriter, reque
 		"delimiter":  request.FormValue("delimiter"),
 	}
 	r, headers, code := server.C.GetContainer(vars["account"], vars["container"], options, request.Header)
-	for k := range headers {
-		writer.Header().Set(k, headers.Get(k))
-	}
+	copyResponseHeaders(writer.Header(), headers)
 	writer.WriteHeader(code)
 	if r != nil {
 		defer r.Close()
@@ -71,9 +73,7 @@ func (server *ProxyServer) ContainerHeadHandler(writer http.ResponseWriter, requ
 		return
 	}
 	headers, code := server.C.HeadContainer(vars["account"], vars["container"], request.Header)
-	for k := range headers {
-		writer.Header().Set(k, headers.Get(k))
-	}
+	copyResponseHeaders(writer.Header(), headers)
 	writer.WriteHeader(code)
 }
 
@@ -19,6 +19,7 @@ import (
 	"flag"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/troubling/hummingbird/client"
@@ -41,6 +42,21 @@ func (server *ProxyServer) HealthcheckHandler(writer http.ResponseWriter, reques
 	return
 }
 
+// backendHeader reports whether key is one of the X-Backend-* headers the proxy and storage servers use between
+// themselves, which clients may neither send nor see.
+func backendHeader(key string) bool {
+	return strings.HasPrefix(http.CanonicalHeaderKey(key), "X-Backend-")
+}
+
+// copyResponseHeaders copies a backend response's headers to dst, leaving out the backend-only ones.
+func copyResponseHeaders(dst, src http.Header) {
+	for k := range src {
+		if !backendHeader(k) {
+			dst.Set(k, src.Get(k))
+		}
+	}
+}
+
 func (server *ProxyServer) LogRequest(next http.Handler) http.Handler {
 	fn := func(writer http.ResponseWriter, request *http.Request) {
 		transId := hummingbird.GetTransactionId()
@@ -50,6 +66,11 @@ func (server *ProxyServer) LogRequest(next http.Handler) http.Handler {
 		defer requestLogger.LogPanics("LOGGING REQUEST")
 		start := time.Now()
 		request = hummingbird.SetLogger(request, requestLogger)
+		for k := range request.Header {
+			if backendHeader(k) {
+				request.Header.Del(k)
+			}
+		}
 		request.Header.Set("X-Trans-Id", transId)
 		newWriter.Header().Set("X-Trans-Id", transId)
 		request.Header.Set("X-Timestamp", hummingbird.GetTimestamp())
@@ -112,7 +133,7 @@ func (server *ProxyServer) GetHandler(config hummingbird.Config) http.Handler {
 func GetServer(serverconf hummingbird.Config, flags *flag.FlagSet) (string, int, hummingbird.Server, hummingbird.LowLevelLogger, error) {
 	var err error
 	server := &ProxyServer{}
-	server.C, err = client.NewProxyDirectClient()
+	server.C, err = client.NewProxyDirectClient(serverconf)
 	if err != nil {
 		return "", 0, nil, nil, err
 	}
@@ -39,9 +39,7 @@ func (server *ProxyServer) ObjectGetHandler(writer http.ResponseWriter, request
 		return
 	}
 	r, headers, code := server.C.GetObject(vars["account"], vars["container"], vars["obj"], request.Header)
-	for k := range headers {
-		writer.Header().Set(k, headers.Get(k))
-	}
+	copyResponseHeaders(writer.Header(), headers)
 	writer.WriteHeader(code)
 	if r != nil {
 		defer r.Close()
@@ -65,9 +63,7 @@ func (server *ProxyServer) ObjectHeadHandler(writer http.ResponseWriter, request
 		return
 	}
 	headers, code := server.C.HeadObject(vars["account"], vars["container"], vars["obj"], request.Header)
-	for k := range headers {
-		writer.Header().Set(k, headers.Get(k))
-	}
+	copyResponseHeaders(writer.Header(), headers)
 	writer.WriteHeader(code)
 }
 
@@ -0,0 +1,83 @@
+//  Copyright (c) 2015 Rackspace
+//
+//  Licensed under the Apache License, Version 2.0 (the "License");
+//  you may not use this file except in compliance with the License.
+//  You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+//  Unless required by applicable law or agreed to in writing, software
+//  distributed under the License is distributed on an "AS IS" BASIS,
+//  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+//  implied.
+//  See the License for the specific language governing permissions and
+//  limitations under the License.
+
+package proxyserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/troubling/hummingbird/client"
+	"github.com/troubling/hummingbird/hummingbird"
+
+	"github.com/stretchr/testify/require"
+)
+
+// notFoundClient answers like an object server that only gives 404 reasons to other backends.
+type notFoundClient struct {
+	client.ProxyClient
+}
+
+func (c *notFoundClient) respond(headers http.Header) http.Header {
+	h := http.Header{}
+	h.Set("X-Backend-Timestamp", "1400000000.00000")
+	for k := range headers {
+		if strings.HasPrefix(k, "X-Backend-") {
+			h.Set("X-Backend-Not-Found-Reason", "expired")
+		}
+	}
+	return h
+}
+
+func (c *notFoundClient) GetObject(account string, container string, obj string, headers http.Header) (io.ReadCloser, http.Header, int) {
+	return nil, c.respond(headers), 404
+}
+
+func (c *notFoundClient) HeadObject(account string, container string, obj string, headers http.Header) (http.Header, int) {
+	return c.respond(headers), 404
+}
+
+func TestObjectBackendHeadersStripped(t *testing.T) {
+	c := &notFoundClient{}
+	server := &ProxyServer{C: c, logger: &SysLogMock{}}
+	for _, method := range []string{"GET", "HEAD"} {
+		handler := server.LogRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = hummingbird.SetVars(r, map[string]string{"account": "a", "container": "c", "obj": "o"})
+			ctx := &ProxyContext{
+				ProxyContextMiddleware: &ProxyContextMiddleware{c: c},
+				accountInfoCache:       map[string]*AccountInfo{"account/a": {}},
+				containerInfoCache:     map[string]*ContainerInfo{"container/c": {}},
+			}
+			r = r.WithContext(context.WithValue(r.Context(), "proxycontext", ctx))
+			if method == "GET" {
+				server.ObjectGetHandler(w, r)
+			} else {
+				server.ObjectHeadHandler(w, r)
+			}
+		}))
+		request, err := http.NewRequest(method, "/v1/a/c/o", nil)
+		require.Nil(t, err)
+		request.Header.Set("X-Backend-Storage-Policy-Index", "0")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, request)
+		require.Equal(t, 404, w.Code)
+		require.Equal(t, "", w.Header().Get("X-Backend-Not-Found-Reason"))
+		require.Equal(t, "", w.Header().Get("X-Backend-Timestamp"))
+	}
+}